@@ -0,0 +1,21 @@
+package api
+
+// Codec transforms message bodies: consumers Encode each body before publishing it and producers
+// Decode each body they consume, so Decode must invert Encode
+type Codec interface {
+	Encode(body []byte) ([]byte, error)
+	Decode(body []byte) ([]byte, error)
+}
+
+var codecs = newRegistry[Codec]("codec")
+
+// RegisterCodec makes codec available to the codecs option as name, replacing any codec already
+// registered as name (including the built in gzip and base64)
+func RegisterCodec(name string, codec Codec) {
+	codecs.register(name, codec)
+}
+
+// LookupCodec is the codec registered as name
+func LookupCodec(name string) (Codec, error) {
+	return codecs.lookup(name)
+}
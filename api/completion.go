@@ -0,0 +1,24 @@
+package api
+
+var completionSignals = newRegistry[chan struct{}]("completion signal")
+
+// Completions is the back channel for a producer configured with ack-signal = name. Instead of
+// acking a message once it's handed off to send, the producer waits for one signal per record it
+// forwarded, in forwarding order, and acks each message once all its records are signalled.
+// A message not fully signalled within ack-timeout of being forwarded is requeued, and a signal
+// arriving after that counts toward the next message, so ack-timeout should be generous.
+// The channel is buffered, sends block once the buffer is full and no producer is waiting
+func Completions(name string) chan<- struct{} {
+	return completionSignal(name)
+}
+
+// Completed is the producer's end of Completions(name)
+func Completed(name string) <-chan struct{} {
+	return completionSignal(name)
+}
+
+func completionSignal(name string) chan struct{} {
+	return completionSignals.loadOrStore(name, func() chan struct{} {
+		return make(chan struct{}, 256)
+	})
+}
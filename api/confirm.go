@@ -0,0 +1,19 @@
+package api
+
+// ConfirmCallback is told the outcome of each message published with confirm: its publish sequence
+// number, its body and whether the broker acked it. Messages still unconfirmed when the channel
+// closes are reported as nacked. Calls are made from a single goroutine in publish order, which
+// is not necessarily the order the broker confirmed in, and may lag the publishes they follow
+type ConfirmCallback func(seq uint64, body []byte, ack bool)
+
+var confirmCallbacks = newRegistry[ConfirmCallback]("confirm callback")
+
+// RegisterConfirmCallback makes callback available to consumers configured with confirm-callback = name
+func RegisterConfirmCallback(name string, callback ConfirmCallback) {
+	confirmCallbacks.register(name, callback)
+}
+
+// LookupConfirmCallback is the callback registered as name
+func LookupConfirmCallback(name string) (ConfirmCallback, error) {
+	return confirmCallbacks.lookup(name)
+}
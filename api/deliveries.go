@@ -0,0 +1,7 @@
+package api
+
+import "github.com/rabbitmq/amqp091-go"
+
+// DeliveryProducer sends each delivery consumed as is on deliveries, then its Exit on errs,
+// closing both when it stops
+type DeliveryProducer func(deliveries chan<- amqp091.Delivery, errs chan<- error)
@@ -0,0 +1,19 @@
+package api
+
+import "github.com/rabbitmq/amqp091-go"
+
+var dialConfigs = newRegistry[amqp091.Config]("dial config")
+
+// RegisterDialConfig makes dial available to the dial-config option as name. Queues referring to it dial
+// with it as is in place of the settings derived from their config, which only fill in its
+// TLSClientConfig when that's nil: the TLS the connection URI asks for, with any tls-pinned-cert-sha256,
+// and the client properties of connection-name and connection-tags it doesn't set itself. Queues with
+// tls-pinned-cert-sha256 refuse to dial with a config having its own TLSClientConfig, that wouldn't check them
+func RegisterDialConfig(name string, dial amqp091.Config) {
+	dialConfigs.register(name, dial)
+}
+
+// LookupDialConfig is the dial config registered as name
+func LookupDialConfig(name string) (amqp091.Config, error) {
+	return dialConfigs.lookup(name)
+}
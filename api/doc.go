@@ -0,0 +1,9 @@
+// Package api is the Go side of the amqp plugin. The plugin is a package main, which Go code can't import,
+// so a host program embedding it imports this package instead, sharing it with the plugin it opens:
+// registering the codecs, callbacks, id generators, tracers and dial configs its config refers to by name,
+// signalling ack-signal completions, and telling apart the Exit and Rejection errors reported on errs.
+//
+// The plugin registers its built in codecs and id generators as it's opened, so register any replacing
+// them after opening it. The plugin's ProduceDeliveries is looked up from it as a
+// func(sdk.Parser) (api.DeliveryProducer, error)
+package api
@@ -0,0 +1,70 @@
+package api
+
+import "fmt"
+
+// ExitReason is why a producer or consumer stopped
+type ExitReason int
+
+const (
+	// ExitStopAfter means the producer forwarded stop-after messages
+	ExitStopAfter ExitReason = iota + 1
+	// ExitInputClosed means the consumer's recv was closed and everything read from it was published
+	ExitInputClosed
+	// ExitCanceled means the producer was cancelled, such as by stop-signal
+	ExitCanceled
+	// ExitBrokerClosed means the broker closed the connection or channel, or cancelled the consumer
+	ExitBrokerClosed
+	// ExitError means the producer or consumer gave up on an error
+	ExitError
+	// ExitQueueEmpty means amqp-dlx-replay replayed everything on its queue
+	ExitQueueEmpty
+)
+
+func (reason ExitReason) String() string {
+	switch reason {
+	case ExitStopAfter:
+		return "stop-after reached"
+	case ExitInputClosed:
+		return "input closed"
+	case ExitCanceled:
+		return "canceled"
+	case ExitBrokerClosed:
+		return "broker closed"
+	case ExitError:
+		return "error"
+	case ExitQueueEmpty:
+		return "queue empty"
+	}
+
+	return fmt.Sprintf("ExitReason(%d)", int(reason))
+}
+
+// Exit is the last value a producer or consumer sends on errs before closing its channels,
+// telling the pipeline driver why it stopped: check for it with errors.As. For a broker close
+// Err is the broker's *amqp091.Error when it gave one, for an error it's the error given up on
+type Exit struct {
+	Reason ExitReason
+	Err    error
+}
+
+func (exit *Exit) Error() string {
+	if exit.Err == nil {
+		return "amqp exited: " + exit.Reason.String()
+	}
+
+	return "amqp exited: " + exit.Reason.String() + ": " + exit.Err.Error()
+}
+
+func (exit *Exit) Unwrap() error {
+	return exit.Err
+}
+
+// Clean reports whether the exit was intended, so there's nothing to restart
+func (exit *Exit) Clean() bool {
+	switch exit.Reason {
+	case ExitStopAfter, ExitInputClosed, ExitCanceled, ExitQueueEmpty:
+		return true
+	}
+
+	return false
+}
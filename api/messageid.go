@@ -0,0 +1,26 @@
+package api
+
+// IDGenerator makes the message id of each message a consumer publishes with message-id, from its body
+type IDGenerator interface {
+	ID(body []byte) (string, error)
+}
+
+// IDGeneratorFunc adapts a function to IDGenerator
+type IDGeneratorFunc func(body []byte) (string, error)
+
+func (generate IDGeneratorFunc) ID(body []byte) (string, error) {
+	return generate(body)
+}
+
+var idGenerators = newRegistry[IDGenerator]("id generator")
+
+// RegisterIDGenerator makes generator available to the message-id-scheme option as name, replacing any
+// generator already registered as name (including the built in uuidv4, uuidv7, ksuid and content)
+func RegisterIDGenerator(name string, generator IDGenerator) {
+	idGenerators.register(name, generator)
+}
+
+// LookupIDGenerator is the generator registered as name
+func LookupIDGenerator(name string) (IDGenerator, error) {
+	return idGenerators.lookup(name)
+}
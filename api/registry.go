@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+)
+
+// registry holds values registered by name from Go, so that config can refer to them by that name.
+// A registered value is shared by every queue referring to it, and must be safe for concurrent use
+type registry[T any] struct {
+	kind    string
+	mu      sync.RWMutex
+	entries map[string]T
+}
+
+func newRegistry[T any](kind string) *registry[T] {
+	return &registry[T]{kind: kind, entries: make(map[string]T)}
+}
+
+func (r *registry[T]) register(name string, value T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[name] = value
+}
+
+func (r *registry[T]) lookup(name string) (T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	value, ok := r.entries[name]
+	if !ok {
+		return value, fmt.Errorf("no %s registered as %q", r.kind, name)
+	}
+
+	return value, nil
+}
+
+// loadOrStore returns the value registered as name, first registering made() as name if there's none
+func (r *registry[T]) loadOrStore(name string, made func() T) T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value, ok := r.entries[name]
+	if !ok {
+		value = made()
+		r.entries[name] = value
+	}
+
+	return value
+}
@@ -0,0 +1,41 @@
+package api
+
+import "fmt"
+
+// RejectReason is why the producer rejected (nacked) a message rather than forwarding it
+type RejectReason string
+
+const (
+	// RejectUndecodable means a codec or the framing failed on the body, the message is
+	// rejected without requeue so a dead letter exchange on the queue receives it
+	RejectUndecodable RejectReason = "undecodable"
+	// RejectAckTimeout means the pipeline didn't signal the message complete within ack-timeout,
+	// it's requeued
+	RejectAckTimeout RejectReason = "ack-timeout"
+	// RejectSampledOut means sample-requeue put the message back in the queue, this is by design
+	// so it's only counted, never reported on errs
+	RejectSampledOut RejectReason = "sampled-out"
+)
+
+// Rejection is sent on errs for each message rejected over a problem
+type Rejection struct {
+	Reason      RejectReason
+	DeliveryTag uint64
+	MessageID   string
+	Requeued    bool
+	// Err is the problem, such as the decoding error
+	Err error
+}
+
+func (rejection *Rejection) Error() string {
+	action := "dead-lettered"
+	if rejection.Requeued {
+		action = "requeued"
+	}
+
+	return fmt.Sprintf("delivery %d %s (%s): %v", rejection.DeliveryTag, action, rejection.Reason, rejection.Err)
+}
+
+func (rejection *Rejection) Unwrap() error {
+	return rejection.Err
+}
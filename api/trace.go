@@ -0,0 +1,20 @@
+package api
+
+// Tracer starts a span around each message a queue consumes or publishes, so OpenTelemetry or any other
+// tracer can be plugged in without this plugin depending on it. Start is given the span name, amqp consume
+// or amqp publish, and its attributes, and returns the func ending the span with the operation's error
+type Tracer interface {
+	Start(name string, attributes map[string]string) (end func(err error))
+}
+
+var tracers = newRegistry[Tracer]("tracer")
+
+// RegisterTracer makes tracer available to the tracer option as name
+func RegisterTracer(name string, tracer Tracer) {
+	tracers.register(name, tracer)
+}
+
+// LookupTracer is the tracer registered as name
+func LookupTracer(name string) (Tracer, error) {
+	return tracers.lookup(name)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	backlogPurge = "purge"
+	backlogSkip  = "skip"
+)
+
+func (config *queueConfig) validateBacklog() error {
+	if !config.SkipBacklog {
+		return nil
+	}
+
+	switch config.SkipBacklogStrategy {
+	case backlogPurge:
+		if config.QueueType == "stream" {
+			return errors.New("stream queues can't be purged, use skip-backlog-strategy skip")
+		}
+	case backlogSkip:
+		// only a stream can be consumed past its backlog, leaving it in place
+		if config.QueueType != "stream" {
+			return errors.New("skip-backlog-strategy skip requires queue-type stream, other queues can only purge their backlog")
+		}
+
+		if config.StreamOffset != "" || config.OffsetStore != "" {
+			return errors.New("skip-backlog consumes a stream from next, it can't be combined with stream-offset or offset-store")
+		}
+	default:
+		return fmt.Errorf("skip-backlog-strategy must be %s or %s, got %q", backlogPurge, backlogSkip, config.SkipBacklogStrategy)
+	}
+
+	return nil
+}
+
+// skipBacklog purges the backlog of each subscription's queue, for every consumer of it, so only messages
+// arriving after it connected are forwarded. A stream is left untouched, consumed from the next message
+func (p *producer) skipBacklog(subs []*subscription) error {
+	if !p.config.SkipBacklog || p.config.SkipBacklogStrategy != backlogPurge {
+		return nil
+	}
+
+	for _, sub := range subs {
+		purged, err := sub.channel.QueuePurge(sub.queue.Name, false)
+		if err != nil {
+			return err
+		}
+
+		count(p.config.Queue, "backlog-purged", int64(purged))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	batchConcat    = "concat"
+	batchNewline   = "newline"
+	batchJSONArray = "json-array"
+)
+
+// batch combines the records of a chunk into the single body emit-chunk-as-batch forwards
+type batch struct {
+	format  string
+	buf     *bytes.Buffer
+	records int
+}
+
+func newBatch(format string) (*batch, error) {
+	switch format {
+	case batchConcat, batchNewline, batchJSONArray:
+	default:
+		return nil, fmt.Errorf("batch-format must be %s, %s or %s, got %q", batchConcat, batchNewline, batchJSONArray, format)
+	}
+
+	return &batch{format: format, buf: new(bytes.Buffer)}, nil
+}
+
+// check reports records that can't be combined, json-array only takes JSON
+func (b *batch) check(records [][]byte) error {
+	if b.format != batchJSONArray {
+		return nil
+	}
+
+	for _, record := range records {
+		if !json.Valid(record) {
+			return errors.New("record isn't JSON, it can't join a json-array batch")
+		}
+	}
+
+	return nil
+}
+
+func (b *batch) add(record []byte) {
+	switch b.format {
+	case batchJSONArray:
+		if b.records == 0 {
+			b.buf.WriteByte('[')
+		} else {
+			b.buf.WriteByte(',')
+		}
+		b.buf.Write(record)
+	case batchNewline:
+		b.buf.Write(record)
+		b.buf.WriteByte('\n')
+	default:
+		b.buf.Write(record)
+	}
+
+	b.records++
+}
+
+// take is the combined body of the records added since the last take
+func (b *batch) take() []byte {
+	if b.format == batchJSONArray {
+		b.buf.WriteByte(']')
+	}
+
+	body := b.buf.Bytes()
+	b.buf, b.records = new(bytes.Buffer), 0
+	return body
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// envelope carries a message between a bridge producer and a bridge consumer, with the properties
+// of its delivery that are otherwise lost going through the pipeline as bytes
+type envelope struct {
+	ContentType string `json:"content-type,omitempty"`
+	Body        []byte `json:"body"`
+}
+
+func wrap(contentType string, body []byte) ([]byte, error) {
+	return json.Marshal(envelope{ContentType: contentType, Body: body})
+}
+
+// wrapAll wraps each record of a delivery with its content type
+func wrapAll(contentType string, records [][]byte) ([][]byte, error) {
+	wrapped := make([][]byte, len(records))
+	for i, record := range records {
+		var err error
+		if wrapped[i], err = wrap(contentType, record); err != nil {
+			return nil, err
+		}
+	}
+
+	return wrapped, nil
+}
+
+func unwrap(data []byte) (envelope, error) {
+	var wrapped envelope
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return envelope{}, fmt.Errorf("bridge envelope: %w", err)
+	}
+
+	return wrapped, nil
+}
+
+// contentType is what to publish a bridged message with: its original content type with preserve-content-type,
+// the configured one otherwise or when it had none
+func (config *queueConfig) contentType(wrapped envelope) string {
+	if config.PreserveContentType && wrapped.ContentType != "" {
+		return wrapped.ContentType
+	}
+
+	return config.ContentType
+}
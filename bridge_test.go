@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBridgeRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		original string
+		preserve bool
+		want     string
+	}{
+		{"preserved json", "application/json", true, "application/json"},
+		{"preserved with parameters", "text/plain; charset=utf-8", true, "text/plain; charset=utf-8"},
+		{"preserved empty", "", true, "application/octet-stream"},
+		{"not preserved", "application/json", false, "application/octet-stream"},
+		{"not preserved empty", "", false, "application/octet-stream"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			body := []byte("{\"binary\": \"\x00\xff\"}")
+			data, err := wrap(test.original, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wrapped, err := unwrap(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(wrapped.Body, body) {
+				t.Errorf("unwrapped body %q, want %q", wrapped.Body, body)
+			}
+
+			if wrapped.ContentType != test.original {
+				t.Errorf("unwrapped content type %q, want %q", wrapped.ContentType, test.original)
+			}
+
+			config := &queueConfig{ContentType: "application/octet-stream", PreserveContentType: test.preserve}
+			if got := config.contentType(wrapped); got != test.want {
+				t.Errorf("contentType() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnwrapInvalid(t *testing.T) {
+	if _, err := unwrap([]byte("not an envelope")); err == nil {
+		t.Error("unwrapped a body that isn't an envelope")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+
+	"github.com/psyduck-std/amqp/api"
+)
+
+func init() {
+	api.RegisterCodec("gzip", gzipCodec{})
+	api.RegisterCodec("base64", base64Codec{})
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Encode(body []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(body []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+type base64Codec struct{}
+
+func (base64Codec) Encode(body []byte) ([]byte, error) {
+	return base64.StdEncoding.AppendEncode(nil, body), nil
+}
+
+func (base64Codec) Decode(body []byte) ([]byte, error) {
+	return base64.StdEncoding.AppendDecode(nil, body)
+}
+
+// codecChain resolves names in the order bodies are encoded
+func codecChain(names []string) ([]api.Codec, error) {
+	chain := make([]api.Codec, len(names))
+	for i, name := range names {
+		codec, err := api.LookupCodec(name)
+		if err != nil {
+			return nil, err
+		}
+
+		chain[i] = codec
+	}
+
+	return chain, nil
+}
+
+func encode(chain []api.Codec, body []byte) ([]byte, error) {
+	for _, codec := range chain {
+		encoded, err := codec.Encode(body)
+		if err != nil {
+			return nil, err
+		}
+
+		body = encoded
+	}
+
+	return body, nil
+}
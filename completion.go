@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// handoff is a message awaiting the completion of its forwarded records
+type handoff struct {
+	msg      *amqp091.Delivery
+	records  int
+	deadline time.Time
+}
+
+// settle acks each handoff once signals has yielded one completion per record,
+// requeueing it when its deadline passes first, until handoffs is closed
+func (p *producer) settle(signals <-chan struct{}) {
+	for next := range p.handoffs {
+		timeout := time.NewTimer(time.Until(next.deadline))
+		completed := 0
+	waiting:
+		for completed < next.records {
+			select {
+			case <-signals:
+				completed++
+			case <-timeout.C:
+				break waiting
+			}
+		}
+		timeout.Stop()
+
+		if completed < next.records {
+			cause := fmt.Errorf("%d of %d records completed", completed, next.records)
+			if err := p.reject(next.msg, api.RejectAckTimeout, true, cause); err != nil {
+				p.errs <- err
+			}
+			continue
+		}
+
+		if err := next.msg.Ack(false); err != nil {
+			p.errs <- err
+		}
+	}
+}
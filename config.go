@@ -0,0 +1,556 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type queueConfig struct {
+	Connection  string `cty:"connection"`
+	Queue       string `cty:"queue"`
+	ContentType string `cty:"content-type"`
+	StopAfter   int    `cty:"stop-after"`
+	ChunkSize   uint   `cty:"chunk-size"`
+	NoWait      bool   `cty:"no-wait"`
+	AutoAck     bool   `cty:"auto-ack"`
+
+	Exchange        string `cty:"exchange"`
+	ExchangeType    string `cty:"exchange-type"`
+	RoutingKey      string `cty:"routing-key"`
+	DelayedExchange bool   `cty:"delayed-exchange"`
+	Delay           int    `cty:"delay"`
+
+	StateFile string `cty:"state-file"`
+
+	DropFilter []string `cty:"drop-filter"`
+
+	QueuePrefix string `cty:"queue-prefix"`
+
+	Reliability string `cty:"reliability"`
+	Confirm     bool   `cty:"confirm"`
+	Mandatory   bool   `cty:"mandatory"`
+
+	ConfirmCallback string `cty:"confirm-callback"`
+
+	Decompress string   `cty:"decompress"`
+	Framing    string   `cty:"framing"`
+	Codecs     []string `cty:"codecs"`
+
+	Priority         int    `cty:"priority"`
+	PriorityJSONPath string `cty:"priority-json-path"`
+
+	MaxPublishRate float64 `cty:"max-publish-rate"`
+	PublishBurst   int     `cty:"publish-burst"`
+	MaxConsumeRate float64 `cty:"max-consume-rate"`
+	ConsumeBurst   int     `cty:"consume-burst"`
+
+	TopologyFile      string `cty:"topology-file"`
+	ReconcileTopology bool   `cty:"reconcile-topology"`
+	AllowDestructive  bool   `cty:"allow-destructive"`
+
+	StopSignal string `cty:"stop-signal"`
+
+	QueueType            string `cty:"queue-type"`
+	Durable              bool   `cty:"durable"`
+	SingleActiveConsumer bool   `cty:"single-active-consumer"`
+
+	SampleRate          float64 `cty:"sample-rate"`
+	SampleRequeue       bool    `cty:"sample-requeue"`
+	SampleRequeueShared bool    `cty:"sample-requeue-shared"`
+
+	GetMode         bool   `cty:"get-mode"`
+	PollInterval    string `cty:"poll-interval"`
+	PollIntervalMax string `cty:"poll-interval-max"`
+
+	CorrelationID            string `cty:"correlation-id"`
+	CorrelationIDFromContent bool   `cty:"correlation-id-from-content"`
+	CorrelationIDJSONPath    string `cty:"correlation-id-json-path"`
+
+	TLSPinnedCertSHA256 []string `cty:"tls-pinned-cert-sha256"`
+
+	NotifyCloseBuffer   int `cty:"notify-close-buffer"`
+	NotifyCancelBuffer  int `cty:"notify-cancel-buffer"`
+	NotifyReturnBuffer  int `cty:"notify-return-buffer"`
+	NotifyPublishBuffer int `cty:"notify-publish-buffer"`
+
+	PrefetchCount int    `cty:"prefetch-count"`
+	StreamOffset  string `cty:"stream-offset"`
+
+	AckSignal  string `cty:"ack-signal"`
+	AckTimeout string `cty:"ack-timeout"`
+
+	QueueNameJSONPath string `cty:"queue-name-json-path"`
+
+	Transactional bool `cty:"transactional"`
+
+	DedupChunkByHeader string `cty:"dedup-chunk-by-header"`
+
+	ErrorBufferSize int    `cty:"error-buffer-size"`
+	ErrorOverflow   string `cty:"error-overflow"`
+
+	RejectSink string `cty:"reject-sink"`
+
+	ReorderHeader     string `cty:"reorder-header"`
+	ReorderWindow     int    `cty:"reorder-window"`
+	ReorderGapTimeout string `cty:"reorder-gap-timeout"`
+
+	MaxLength            int    `cty:"max-length"`
+	Overflow             string `cty:"overflow"`
+	DeadLetterExchange   string `cty:"dead-letter-exchange"`
+	DeadLetterRoutingKey string `cty:"dead-letter-routing-key"`
+
+	ChunkOrder string `cty:"chunk-order"`
+
+	Selector string `cty:"selector"`
+
+	KeepaliveInterval string `cty:"keepalive-interval"`
+	KeepalivePayload  string `cty:"keepalive-payload"`
+	KeepaliveQueue    string `cty:"keepalive-queue"`
+
+	DrainTimeout string `cty:"drain-timeout"`
+
+	Bridge              bool `cty:"bridge"`
+	PreserveContentType bool `cty:"preserve-content-type"`
+
+	Connections     []string `cty:"connections"`
+	ConnectionOrder string   `cty:"connection-order"`
+
+	DelayQueue    string `cty:"delay-queue"`
+	DelayQueueTTL string `cty:"delay-queue-ttl"`
+
+	Sources []vhostSource `cty:"sources"`
+
+	MessageID       bool   `cty:"message-id"`
+	MessageIDScheme string `cty:"message-id-scheme"`
+
+	OffsetStore          string `cty:"offset-store"`
+	OffsetCommitInterval string `cty:"offset-commit-interval"`
+
+	DialConfig string `cty:"dial-config"`
+
+	SkipBacklog         bool   `cty:"skip-backlog"`
+	SkipBacklogStrategy string `cty:"skip-backlog-strategy"`
+
+	Tracer          string   `cty:"tracer"`
+	TraceAttributes []string `cty:"trace-attributes"`
+
+	OnConnectionClose string `cty:"on-connection-close"`
+	ReconnectInterval string `cty:"reconnect-interval"`
+
+	ConnectionName string   `cty:"connection-name"`
+	ConnectionTags []string `cty:"connection-tags"`
+
+	DeliveryBuffer int `cty:"delivery-buffer"`
+
+	EmitChunkAsBatch bool   `cty:"emit-chunk-as-batch"`
+	BatchFormat      string `cty:"batch-format"`
+
+	// ackAfterSend defers the producer's acks until a chunk is forwarded, set by reliability
+	ackAfterSend bool
+	// topology is loaded from topology-file
+	topology *topology
+	// role is producer or consumer, describing the config in declaration conflicts
+	role string
+}
+
+const (
+	atMostOnce  = "at-most-once"
+	atLeastOnce = "at-least-once"
+)
+
+// parseConfig parses, validates and resolves a queueConfig, ready for connect
+func parseConfig(parse sdk.Parser) (*queueConfig, error) {
+	config := new(queueConfig)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.TopologyFile != "" {
+		desired, err := loadTopology(config.TopologyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.topology = desired
+	}
+
+	config.applyReliability()
+	config.applyPrefix()
+	return config, nil
+}
+
+func (config *queueConfig) validate() error {
+	if config.Delay < 0 {
+		return fmt.Errorf("delay must not be negative, got %d", config.Delay)
+	}
+
+	if config.Delay != 0 && !config.DelayedExchange {
+		return errors.New("delay requires delayed-exchange")
+	}
+
+	if config.DelayedExchange && config.Exchange == "" {
+		return errors.New("delayed-exchange requires an exchange")
+	}
+
+	if config.Priority < 0 || config.Priority > 255 {
+		return fmt.Errorf("priority must be within 0-255, got %d", config.Priority)
+	}
+
+	switch config.QueueType {
+	case "", "classic":
+	case "quorum", "stream":
+		if !config.Durable {
+			return fmt.Errorf("%s queues must be durable", config.QueueType)
+		}
+	default:
+		return fmt.Errorf("queue-type must be classic, quorum or stream, got %q", config.QueueType)
+	}
+
+	if config.MaxLength < 0 {
+		return fmt.Errorf("max-length must not be negative, got %d", config.MaxLength)
+	}
+
+	// quorum queues can't dead letter overflow, and streams are bounded by retention instead
+	switch config.Overflow {
+	case "":
+	case "drop-head", "reject-publish", "reject-publish-dlx":
+		if config.MaxLength == 0 {
+			return fmt.Errorf("overflow %s requires max-length", config.Overflow)
+		}
+	default:
+		return fmt.Errorf("overflow must be drop-head, reject-publish or reject-publish-dlx, got %q", config.Overflow)
+	}
+
+	if config.QueueType == "stream" && (config.MaxLength != 0 || config.Overflow != "" || config.DeadLetterExchange != "") {
+		return errors.New("stream queues don't support max-length, overflow or dead-letter-exchange")
+	}
+
+	if config.Overflow == "reject-publish-dlx" && config.QueueType == "quorum" {
+		return errors.New("quorum queues don't support overflow reject-publish-dlx, use reject-publish")
+	}
+
+	if config.Overflow == "reject-publish-dlx" && config.DeadLetterExchange == "" {
+		return errors.New("overflow reject-publish-dlx requires a dead-letter-exchange")
+	}
+
+	if config.DeadLetterRoutingKey != "" && config.DeadLetterExchange == "" {
+		return errors.New("dead-letter-routing-key requires a dead-letter-exchange")
+	}
+
+	if config.SingleActiveConsumer && config.QueueType == "stream" {
+		return errors.New("single-active-consumer isn't supported for stream queues consumed over AMQP 0-9-1")
+	}
+
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		return fmt.Errorf("sample-rate must be within (0, 1], got %g", config.SampleRate)
+	}
+
+	// a requeued sample goes back to whichever consumer the broker picks: when that's always this one,
+	// it's redelivered until sampled in, forwarding everything through a busy requeue loop
+	if config.SampleRequeue && (!config.SampleRequeueShared || config.SingleActiveConsumer) {
+		return errors.New("sample-requeue only samples a queue other consumers share, set sample-requeue-shared to confirm they do, without single-active-consumer")
+	}
+
+	if config.CorrelationIDJSONPath != "" && !config.CorrelationIDFromContent {
+		return errors.New("correlation-id-json-path requires correlation-id-from-content")
+	}
+
+	if _, err := config.parsePins(); err != nil {
+		return err
+	}
+
+	// amqp091 blocks delivering a notification until there's room for it,
+	// an unbuffered close or cancel notification nobody is waiting on would stall it
+	for name, size := range map[string]int{
+		"notify-close-buffer":   config.NotifyCloseBuffer,
+		"notify-cancel-buffer":  config.NotifyCancelBuffer,
+		"notify-return-buffer":  config.NotifyReturnBuffer,
+		"notify-publish-buffer": config.NotifyPublishBuffer,
+	} {
+		if size < 1 {
+			return fmt.Errorf("%s must be at least 1, got %d", name, size)
+		}
+	}
+
+	if config.PrefetchCount < 0 {
+		return fmt.Errorf("prefetch-count must not be negative, got %d", config.PrefetchCount)
+	}
+
+	if config.StreamOffset != "" && config.QueueType != "stream" {
+		return errors.New("stream-offset requires queue-type stream")
+	}
+
+	if _, err := config.streamOffset(); err != nil {
+		return err
+	}
+
+	if config.ErrorBufferSize < 0 {
+		return fmt.Errorf("error-buffer-size must not be negative, got %d", config.ErrorBufferSize)
+	}
+
+	switch config.ErrorOverflow {
+	case overflowBlock, overflowDropOldest, overflowCoalesce:
+	default:
+		return fmt.Errorf("error-overflow must be %s, %s or %s, got %q", overflowBlock, overflowDropOldest, overflowCoalesce, config.ErrorOverflow)
+	}
+
+	if config.ReorderHeader != "" && config.ReorderWindow < 1 {
+		return fmt.Errorf("reorder-window must be at least 1, got %d", config.ReorderWindow)
+	}
+
+	switch config.ChunkOrder {
+	case "fifo", "lifo":
+	default:
+		return fmt.Errorf("chunk-order must be fifo or lifo, got %q", config.ChunkOrder)
+	}
+
+	if config.Connection == "" && len(config.Connections) == 0 && len(config.Sources) == 0 {
+		return errors.New("connection, connections or sources is required")
+	}
+
+	switch config.ConnectionOrder {
+	case "ordered", "random":
+	default:
+		return fmt.Errorf("connection-order must be ordered or random, got %q", config.ConnectionOrder)
+	}
+
+	if (config.DelayQueue == "") != (config.DelayQueueTTL == "") {
+		return errors.New("delay-queue and delay-queue-ttl must be set together")
+	}
+
+	if config.DelayQueue != "" {
+		if _, err := config.delayQueueTTL(); err != nil {
+			return err
+		}
+
+		if config.DelayedExchange {
+			return errors.New("delay-queue and delayed-exchange are alternatives, set one")
+		}
+	}
+
+	for i, source := range config.Sources {
+		if source.Connection == "" || source.Queue == "" {
+			return fmt.Errorf("sources[%d] needs both a connection and a queue", i)
+		}
+	}
+
+	if err := config.validateBacklog(); err != nil {
+		return err
+	}
+
+	if err := config.validateTraceAttributes(); err != nil {
+		return err
+	}
+
+	if err := config.validateTags(); err != nil {
+		return err
+	}
+
+	if config.DeliveryBuffer < 0 {
+		return fmt.Errorf("delivery-buffer must not be negative, got %d", config.DeliveryBuffer)
+	}
+
+	if config.PrefetchCount != 0 && config.DeliveryBuffer > config.PrefetchCount {
+		return fmt.Errorf("delivery-buffer %d can't fill past prefetch-count %d", config.DeliveryBuffer, config.PrefetchCount)
+	}
+
+	switch config.OnConnectionClose {
+	case closeStop, closeReconnect, closeError:
+	default:
+		return fmt.Errorf("on-connection-close must be %s, %s or %s, got %q", closeStop, closeReconnect, closeError, config.OnConnectionClose)
+	}
+
+	switch config.Reliability {
+	case "", atMostOnce, atLeastOnce:
+	default:
+		return fmt.Errorf("reliability must be %s or %s, got %q", atMostOnce, atLeastOnce, config.Reliability)
+	}
+
+	return nil
+}
+
+// applyReliability overrides the low level delivery options with the coherent set for the chosen reliability:
+//
+//   - at-most-once: the producer auto-acks, the consumer publishes without confirm or mandatory
+//   - at-least-once: the producer acks each chunk only after forwarding it, the consumer publishes
+//     with confirm and mandatory, reporting nacked and returned messages on errs
+//
+// with no reliability set auto-ack, confirm and mandatory are used as given
+func (config *queueConfig) applyReliability() {
+	switch config.Reliability {
+	case atMostOnce:
+		config.AutoAck = true
+		config.ackAfterSend = false
+		config.Confirm = false
+		config.Mandatory = false
+	case atLeastOnce:
+		config.AutoAck = false
+		config.ackAfterSend = true
+		config.Confirm = true
+		config.Mandatory = true
+	}
+}
+
+// applyPrefix namespaces the queue and exchange names with queue-prefix, it must run before connect
+// so that declares, binds and publishes all see the prefixed names
+func (config *queueConfig) applyPrefix() {
+	config.Queue = config.QueuePrefix + config.Queue
+	if config.Exchange != "" {
+		config.Exchange = config.QueuePrefix + config.Exchange
+	}
+
+	if config.RejectSink != "" {
+		config.RejectSink = config.QueuePrefix + config.RejectSink
+	}
+
+	if config.DeadLetterExchange != "" {
+		config.DeadLetterExchange = config.QueuePrefix + config.DeadLetterExchange
+	}
+
+	if config.KeepaliveQueue != "" {
+		config.KeepaliveQueue = config.QueuePrefix + config.KeepaliveQueue
+	}
+
+	if config.DelayQueue != "" {
+		config.DelayQueue = config.QueuePrefix + config.DelayQueue
+	}
+
+	for i := range config.Sources {
+		config.Sources[i].Queue = config.QueuePrefix + config.Sources[i].Queue
+	}
+
+	if config.topology != nil {
+		config.topology.prefix(config.QueuePrefix)
+	}
+}
+
+func (config *queueConfig) dropFilters() ([]*regexp.Regexp, error) {
+	filters := make([]*regexp.Regexp, len(config.DropFilter))
+	for i, rule := range config.DropFilter {
+		filter, err := regexp.Compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("drop-filter %q: %w", rule, err)
+		}
+
+		filters[i] = filter
+	}
+
+	return filters, nil
+}
+
+var signals = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// stopSignal resolves stop-signal, nil when disabled
+func (config *queueConfig) stopSignal() (os.Signal, error) {
+	if config.StopSignal == "" {
+		return nil, nil
+	}
+
+	sig, ok := signals[config.StopSignal]
+	if !ok {
+		return nil, fmt.Errorf("unsupported stop-signal %q", config.StopSignal)
+	}
+
+	return sig, nil
+}
+
+// queueArgs are the x-arguments the queue is declared with
+func (config *queueConfig) queueArgs() amqp091.Table {
+	args := amqp091.Table{}
+	if config.QueueType != "" {
+		args["x-queue-type"] = config.QueueType
+	}
+
+	if config.SingleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+
+	if config.MaxLength != 0 {
+		args["x-max-length"] = int64(config.MaxLength)
+	}
+
+	if config.Overflow != "" {
+		args["x-overflow"] = config.Overflow
+	}
+
+	if config.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = config.DeadLetterExchange
+	}
+
+	if config.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = config.DeadLetterRoutingKey
+	}
+
+	return args
+}
+
+// streamOffset is the x-stream-offset to consume from: first, last or next as is,
+// an offset as an integer, or an RFC3339 timestamp as a timestamp
+func (config *queueConfig) streamOffset() (any, error) {
+	switch config.StreamOffset {
+	case "":
+		return nil, nil
+	case "first", "last", "next":
+		return config.StreamOffset, nil
+	}
+
+	if offset, err := strconv.ParseInt(config.StreamOffset, 10, 64); err == nil {
+		if offset < 0 {
+			return nil, fmt.Errorf("stream-offset must not be negative, got %d", offset)
+		}
+
+		return offset, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, config.StreamOffset)
+	if err != nil {
+		return nil, fmt.Errorf("stream-offset must be first, last, next, an offset or an RFC3339 timestamp, got %q", config.StreamOffset)
+	}
+
+	return timestamp, nil
+}
+
+// consumeArgs are the x-arguments the producer consumes with
+func (config *queueConfig) consumeArgs() (amqp091.Table, error) {
+	args := amqp091.Table{}
+	offset, err := config.streamOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != nil {
+		args["x-stream-offset"] = offset
+	}
+
+	if config.SkipBacklog && config.QueueType == "stream" {
+		args["x-stream-offset"] = "next"
+	}
+
+	return args, nil
+}
+
+func (config *queueConfig) routingKey() string {
+	if config.RoutingKey != "" {
+		return config.RoutingKey
+	}
+
+	return config.Queue
+}
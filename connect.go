@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func declareExchange(d *declarer, config *queueConfig) error {
+	if !config.DelayedExchange {
+		return d.declareExchange(config.Exchange, config.ExchangeType, false, false, false, nil)
+	}
+
+	// the broker doesn't advertise plugins among its capabilities, so the declare itself is the probe:
+	// an unknown exchange type is refused with COMMAND_INVALID
+	err := d.declareExchange(config.Exchange, "x-delayed-message", false, false, false, amqp091.Table{
+		"x-delayed-type": config.ExchangeType,
+	})
+
+	amqpErr := new(amqp091.Error)
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp091.CommandInvalid {
+		return fmt.Errorf("broker refused x-delayed-message exchange %q, is rabbitmq_delayed_message_exchange enabled? %w", config.Exchange, err)
+	}
+
+	return err
+}
+
+func connect(config *queueConfig) (*amqp091.Connection, *amqp091.Channel, amqp091.Queue, error) {
+	conn, uri, err := config.dial()
+	if err != nil {
+		return nil, nil, amqp091.Queue{}, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, nil, amqp091.Queue{}, err
+	}
+
+	d := &declarer{
+		conn:        conn,
+		channel:     channel,
+		reconcile:   config.ReconcileTopology,
+		destructive: config.AllowDestructive,
+		broker:      broker(uri),
+		owner:       config.describe(),
+	}
+
+	// what's declared is held against conflicting declares until the connection closes
+	go func() {
+		for range conn.NotifyClose(make(chan *amqp091.Error, 1)) {
+		}
+
+		d.release()
+	}()
+
+	if config.topology != nil {
+		if err := config.topology.declare(d); err != nil {
+			conn.Close()
+			return nil, nil, amqp091.Queue{}, err
+		}
+	}
+
+	// TODO sdk should support an object, where we would have queue declare options set
+	// but for now, just defaults
+	queue, err := d.declareQueue(config.Queue, config.Durable, false, false, config.queueArgs())
+	if err != nil {
+		conn.Close()
+		return nil, nil, amqp091.Queue{}, err
+	}
+
+	if config.Exchange != "" {
+		if err := declareExchange(d, config); err != nil {
+			conn.Close()
+			return nil, nil, amqp091.Queue{}, err
+		}
+	}
+
+	if config.topology != nil {
+		if err := config.topology.bind(d); err != nil {
+			conn.Close()
+			return nil, nil, amqp091.Queue{}, err
+		}
+	}
+
+	if config.Exchange != "" {
+		if err := d.channel.QueueBind(queue.Name, config.routingKey(), config.Exchange, false, nil); err != nil {
+			conn.Close()
+			return nil, nil, amqp091.Queue{}, err
+		}
+	}
+
+	if config.DelayQueue != "" {
+		if err := declareDelayQueue(d, config, queue.Name); err != nil {
+			conn.Close()
+			return nil, nil, amqp091.Queue{}, err
+		}
+	}
+
+	return conn, d.channel, queue, nil
+}
+
+func disconnect(conn *amqp091.Connection, channel *amqp091.Channel, errs chan<- error) {
+	if err := conn.Close(); err != nil {
+		errs <- err
+	}
+
+	if err := channel.Close(); err != nil {
+		errs <- err
+	}
+}
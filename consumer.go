@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func dropped(filters []*regexp.Regexp, body []byte) bool {
+	for _, filter := range filters {
+		if filter.Match(body) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// priority reads the priority of body from priority-json-path, falling back to the static priority
+func (config *queueConfig) priority(body []byte) uint8 {
+	if config.PriorityJSONPath == "" {
+		return uint8(config.Priority)
+	}
+
+	value, ok := lookupJSON(body, config.PriorityJSONPath)
+	if !ok {
+		return uint8(config.Priority)
+	}
+
+	number, ok := value.(float64)
+	if !ok {
+		return uint8(config.Priority)
+	}
+
+	return uint8(max(0, min(255, number)))
+}
+
+type pendingConfirm struct {
+	confirm *amqp091.DeferredConfirmation
+	body    []byte
+	// keepalive confirms aren't passed to confirm-callback
+	keepalive bool
+}
+
+// correlationID is the static correlation-id if set, otherwise with correlation-id-from-content
+// the hex SHA-256 of the body, or of the JSON encoding of the value at correlation-id-json-path
+// (the whole body when that can't be read), so identical payloads share a correlation id
+func (config *queueConfig) correlationID(body []byte) string {
+	if config.CorrelationID != "" || !config.CorrelationIDFromContent {
+		return config.CorrelationID
+	}
+
+	if config.CorrelationIDJSONPath != "" {
+		if value, ok := lookupJSON(body, config.CorrelationIDJSONPath); ok {
+			if field, err := json.Marshal(value); err == nil {
+				body = field
+			}
+		}
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func consume(config *queueConfig) (sdk.Consumer, error) {
+	config.role = "consumer"
+	filters, err := config.dropFilters()
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := codecChain(config.Codecs)
+	if err != nil {
+		return nil, err
+	}
+
+	var callback api.ConfirmCallback
+	if config.ConfirmCallback != "" {
+		if !config.Confirm {
+			return nil, errors.New("confirm-callback requires confirm")
+		}
+
+		if callback, err = api.LookupConfirmCallback(config.ConfirmCallback); err != nil {
+			return nil, err
+		}
+	}
+
+	throttle, err := newLimiter(config.MaxPublishRate, config.PublishBurst)
+	if err != nil {
+		return nil, fmt.Errorf("max-publish-rate: %w", err)
+	}
+
+	var generator api.IDGenerator
+	if config.MessageID {
+		if generator, err = api.LookupIDGenerator(config.MessageIDScheme); err != nil {
+			return nil, err
+		}
+	}
+
+	var tracer api.Tracer
+	if config.Tracer != "" {
+		if tracer, err = api.LookupTracer(config.Tracer); err != nil {
+			return nil, err
+		}
+	}
+
+	keepaliveInterval, err := config.keepaliveInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, channel, queue, err := connect(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if keepaliveInterval != 0 && config.KeepaliveQueue != "" {
+		if _, err := channel.QueueDeclare(config.KeepaliveQueue, config.Durable, false, false, false, nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if config.Confirm {
+		if err := channel.Confirm(false); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return func(recv <-chan []byte, out chan<- error, done chan<- struct{}) {
+		errs, flush := bufferErrors(out, config.ErrorBufferSize, config.ErrorOverflow)
+		watchers := new(sync.WaitGroup)
+		// the exit goes straight to out, it mustn't be dropped or coalesced
+		exit := &api.Exit{Reason: api.ExitInputClosed}
+		defer close(done)
+		defer close(out)
+		defer func() { out <- exit }()
+		defer flush()
+		defer watchers.Wait()
+		defer disconnect(conn, channel, errs)
+
+		closes := channel.NotifyClose(make(chan *amqp091.Error, config.NotifyCloseBuffer))
+
+		var router *queueRouter
+		if config.QueueNameJSONPath != "" {
+			router = newQueueRouter(config, conn)
+			defer func() {
+				if err := router.close(); err != nil {
+					errs <- err
+				}
+			}()
+		}
+
+		if config.Mandatory {
+			returns := channel.NotifyReturn(make(chan amqp091.Return, config.NotifyReturnBuffer))
+			watchers.Add(1)
+			go func() {
+				defer watchers.Done()
+				for r := range returns {
+					errs <- fmt.Errorf("message returned unroutable from %q with key %q: %d %s", r.Exchange, r.RoutingKey, r.ReplyCode, r.ReplyText)
+				}
+			}()
+		}
+
+		// confirms are awaited in publish order off the publish path, the channel is only
+		// closed once every outstanding confirm is resolved
+		confirms, confirmed := make(chan pendingConfirm, config.NotifyPublishBuffer), make(chan struct{})
+		go func() {
+			defer close(confirmed)
+			for pending := range confirms {
+				ack := pending.confirm.Wait()
+				if callback != nil && !pending.keepalive {
+					callback(pending.confirm.DeliveryTag, pending.body, ack)
+				}
+
+				if !ack {
+					errs <- fmt.Errorf("broker nacked publish %d", pending.confirm.DeliveryTag)
+				}
+			}
+		}()
+		defer func() {
+			close(confirms)
+			<-confirmed
+		}()
+
+		destination, routingKey := config.Exchange, queue.Name
+		if config.Exchange != "" {
+			routingKey = config.routingKey()
+		}
+
+		// the delay queue dead letters messages on to the destination once they expire
+		if config.DelayQueue != "" {
+			destination, routingKey = "", config.DelayQueue
+		}
+
+		var headers amqp091.Table
+		if config.Delay != 0 {
+			headers = amqp091.Table{"x-delay": config.Delay}
+		}
+
+		// keepalives go out on the loop publishing messages, so they're ordered and confirmed among them
+		var timer *time.Timer
+		var idle <-chan time.Time
+		var keepalive func()
+		if keepaliveInterval != 0 {
+			timer = time.NewTimer(keepaliveInterval)
+			defer timer.Stop()
+			idle = timer.C
+
+			exchange, key := destination, routingKey
+			if config.KeepaliveQueue != "" {
+				exchange, key = "", config.KeepaliveQueue
+			}
+
+			keepalive = func() {
+				defer restart(timer, keepaliveInterval)
+				confirm, err := channel.PublishWithDeferredConfirmWithContext(context.Background(), exchange, key, false, false, amqp091.Publishing{
+					ContentType: config.ContentType,
+					Headers:     amqp091.Table{"x-keepalive": true},
+					Body:        []byte(config.KeepalivePayload),
+				})
+				if err != nil {
+					errs <- err
+				} else if confirm != nil {
+					confirms <- pendingConfirm{confirm: confirm, keepalive: true}
+				}
+				count(key, "keepalives", 1)
+			}
+		}
+
+		for {
+			var d []byte
+			select {
+			case next, ok := <-recv:
+				if !ok {
+					return
+				}
+
+				d = next
+				if timer != nil {
+					restart(timer, keepaliveInterval)
+				}
+			case <-idle:
+				keepalive()
+				continue
+			case err := <-closes:
+				exit = &api.Exit{Reason: api.ExitBrokerClosed}
+				if err != nil {
+					exit.Err = err
+				}
+				return
+			}
+
+			contentType := config.ContentType
+			if config.Bridge {
+				wrapped, err := unwrap(d)
+				if err != nil {
+					errs <- err
+					continue
+				}
+
+				d, contentType = wrapped.Body, config.contentType(wrapped)
+			}
+
+			if dropped(filters, d) {
+				count(queue.Name, "dropped", 1)
+				continue
+			}
+
+			body, err := encode(chain, d)
+			if err != nil {
+				errs <- err
+				continue
+			}
+
+			exchange, key := destination, routingKey
+			if router != nil {
+				if queue, ok := router.route(d); ok {
+					if err := router.declare(queue); err != nil {
+						errs <- err
+						continue
+					}
+
+					exchange, key = "", queue
+				}
+			}
+
+			var messageID string
+			if generator != nil {
+				if messageID, err = generator.ID(d); err != nil {
+					errs <- fmt.Errorf("message-id: %w", err)
+					continue
+				}
+			}
+
+			publishing := amqp091.Publishing{
+				ContentType:   contentType,
+				MessageId:     messageID,
+				Headers:       headers,
+				Priority:      config.priority(d),
+				CorrelationId: config.correlationID(d),
+				Body:          body,
+			}
+
+			throttle.wait()
+			end := config.span(tracer, "amqp publish", spanProperties{
+				queue:         queue.Name,
+				exchange:      exchange,
+				routingKey:    key,
+				messageID:     publishing.MessageId,
+				correlationID: publishing.CorrelationId,
+				contentType:   publishing.ContentType,
+				priority:      publishing.Priority,
+				bodySize:      len(body),
+			})
+			confirm, err := channel.PublishWithDeferredConfirmWithContext(context.Background(), exchange, key, config.Mandatory, false, publishing)
+			end(err)
+			if err != nil {
+				errs <- err
+			} else if confirm != nil {
+				confirms <- pendingConfirm{confirm: confirm, body: d}
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// declaration is what a queue or exchange was declared with, and by which config
+type declaration struct {
+	// kind is the exchange type, empty for a queue
+	kind       string
+	durable    bool
+	autoDelete bool
+	// flag is exclusive for a queue and internal for an exchange
+	flag  bool
+	args  amqp091.Table
+	owner string
+	// holders counts the live connections that declared it
+	holders int
+}
+
+// declarations are every queue and exchange declared through a declarer in this process, so pipelines
+// declaring the same one differently are refused before the broker sees either declare.
+//
+// The rules are:
+//   - declarations are keyed by broker host, vhost, whether it's a queue or exchange, and name, so the same
+//     name on another vhost or broker, or a queue sharing an exchange's name, never conflicts
+//   - a redeclare conflicts when its durable, auto-delete, exclusive (queues) or internal (exchanges) flags,
+//     its exchange type, or its arguments differ; integer arguments compare by value whatever their width,
+//     and a missing argument differs from any set one
+//   - identical redeclares always pass, whichever config made them
+//   - a declaration is held while any connection that declared it is open: once they're all closed the
+//     next declare of that name is free to differ, as a restarted pipeline with a changed config does
+//   - reconcile-topology doesn't bypass it, as two pipelines reconciling a queue to different configs would
+//     otherwise keep deleting it from under each other
+//
+// Declares made without a declarer, of reject-sink, keepalive-queue and queues from queue-name-json-path,
+// aren't checked
+var declarations = struct {
+	sync.Mutex
+	declared map[string]*declaration
+}{declared: make(map[string]*declaration)}
+
+// broker identifies the broker and vhost of uri for declarations
+func broker(uri string) string {
+	parsed, err := amqp091.ParseURI(uri)
+	if err != nil {
+		return uri
+	}
+
+	return fmt.Sprintf("%s:%d/%s", parsed.Host, parsed.Port, parsed.Vhost)
+}
+
+// describe names config in conflicts by its role, queue and binding, and connection-name if it has one,
+// which tells apart configs otherwise alike
+func (config *queueConfig) describe() string {
+	description := fmt.Sprintf("the %s of queue %q", config.role, config.Queue)
+	if config.Exchange != "" {
+		description += fmt.Sprintf(" bound to exchange %q by %q", config.Exchange, config.routingKey())
+	}
+
+	if config.ConnectionName != "" {
+		description += fmt.Sprintf(" (connection-name %q)", config.ConnectionName)
+	}
+
+	return description
+}
+
+// hold records decl as what's declared with name, unless it conflicts with how another config declared it
+func (d *declarer) hold(what, name string, decl *declaration) error {
+	key := d.broker + "\n" + what + "\n" + name
+	declarations.Lock()
+	defer declarations.Unlock()
+
+	held, ok := declarations.declared[key]
+	if !ok {
+		decl.holders = 1
+		declarations.declared[key] = decl
+		d.held = append(d.held, key)
+		return nil
+	}
+
+	if difference := held.differs(decl); difference != "" {
+		return fmt.Errorf("%s %q declared by %s conflicts with its declaration by %s: %s", what, name, decl.owner, held.owner, difference)
+	}
+
+	held.holders++
+	d.held = append(d.held, key)
+	return nil
+}
+
+// release drops each hold of d, once its connection is closed
+func (d *declarer) release() {
+	declarations.Lock()
+	defer declarations.Unlock()
+
+	for _, key := range d.held {
+		held, ok := declarations.declared[key]
+		if !ok {
+			continue
+		}
+
+		if held.holders--; held.holders == 0 {
+			delete(declarations.declared, key)
+		}
+	}
+
+	d.held = nil
+}
+
+// differs describes the first difference of decl from held, empty when they're the same
+func (held *declaration) differs(decl *declaration) string {
+	flag := "exclusive"
+	if held.kind != "" {
+		flag = "internal"
+	}
+
+	switch {
+	case held.kind != decl.kind:
+		return fmt.Sprintf("type %q, was %q", decl.kind, held.kind)
+	case held.durable != decl.durable:
+		return fmt.Sprintf("durable %t, was %t", decl.durable, held.durable)
+	case held.autoDelete != decl.autoDelete:
+		return fmt.Sprintf("auto-delete %t, was %t", decl.autoDelete, held.autoDelete)
+	case held.flag != decl.flag:
+		return fmt.Sprintf("%s %t, was %t", flag, decl.flag, held.flag)
+	}
+
+	keys := make([]string, 0, len(held.args)+len(decl.args))
+	for key := range held.args {
+		keys = append(keys, key)
+	}
+
+	for key := range decl.args {
+		if _, ok := held.args[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+	for _, key := range keys {
+		was, wasSet := held.args[key]
+		is, isSet := decl.args[key]
+		switch {
+		case !isSet:
+			return fmt.Sprintf("argument %s unset, was %v", key, was)
+		case !wasSet:
+			return fmt.Sprintf("argument %s %v, was unset", key, is)
+		case !argEqual(was, is):
+			return fmt.Sprintf("argument %s %v, was %v", key, is, was)
+		}
+	}
+
+	return ""
+}
+
+func argEqual(a, b any) bool {
+	if a, ok := argInt(a); ok {
+		b, ok := argInt(b)
+		return ok && a == b
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// argInt widens an integer argument, unlike headerInt not reading strings as numbers
+func argInt(value any) (int64, bool) {
+	if _, ok := value.(string); ok {
+		return 0, false
+	}
+
+	return headerInt(value)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// delayQueueTTL is how long messages wait in delay-queue
+func (config *queueConfig) delayQueueTTL() (time.Duration, error) {
+	ttl, err := time.ParseDuration(config.DelayQueueTTL)
+	if err != nil {
+		return 0, fmt.Errorf("delay-queue-ttl: %w", err)
+	}
+
+	if ttl < time.Millisecond {
+		return 0, fmt.Errorf("delay-queue-ttl must be at least 1ms, got %s", ttl)
+	}
+
+	return ttl, nil
+}
+
+// declareDelayQueue declares delay-queue holding each message for delay-queue-ttl, then dead lettering it
+// to where the consumer would otherwise publish it: the exchange with its routing key, or the queue
+func declareDelayQueue(d *declarer, config *queueConfig, queue string) error {
+	ttl, err := config.delayQueueTTL()
+	if err != nil {
+		return err
+	}
+
+	args := amqp091.Table{
+		"x-message-ttl":             ttl.Milliseconds(),
+		"x-dead-letter-exchange":    config.Exchange,
+		"x-dead-letter-routing-key": queue,
+	}
+	if config.Exchange != "" {
+		args["x-dead-letter-routing-key"] = config.routingKey()
+	}
+
+	_, err = d.declareQueue(config.DelayQueue, config.Durable, false, false, args)
+	return err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// ProduceDeliveries is an amqp-queue producer for Go embedders, parsing the same config, that forwards
+// whole deliveries rather than their bodies. Hosts look it up from the opened plugin as a
+// func(sdk.Parser) (api.DeliveryProducer, error). Unless auto-ack is set, acking, nacking or rejecting each
+// delivery is then the caller's responsibility: the producer never settles one, and prefetch-count bounds
+// how many may be left unsettled at once. Deliveries still unsettled when the producer exits and
+// disconnects are requeued by the broker, and can no longer be settled. Options acting on bodies, acks, chunks
+// or the producer's own state, such as codecs, ack-signal, transactional or skip-backlog, are refused.
+// A tracer spans each delivery's hand off, and on-connection-close stop exits canceled on a broker close
+func ProduceDeliveries(parse sdk.Parser) (api.DeliveryProducer, error) {
+	config, err := parseConfig(parse)
+	if err != nil {
+		return nil, err
+	}
+
+	config.role = "producer"
+
+	for option, set := range map[string]bool{
+		"codecs, decompress and framing": len(config.Codecs) != 0 || config.Decompress != "" || config.Framing != "",
+		"ack-signal":                     config.AckSignal != "",
+		"transactional":                  config.Transactional,
+		"sample-rate":                    config.SampleRate != 1,
+		"selector":                       config.Selector != "",
+		"dedup-chunk-by-header":          config.DedupChunkByHeader != "",
+		"reorder-header":                 config.ReorderHeader != "",
+		"drain-timeout":                  config.DrainTimeout != "",
+		"offset-store":                   config.OffsetStore != "",
+		"bridge":                         config.Bridge,
+		"max-consume-rate":               config.MaxConsumeRate != 0,
+		"skip-backlog":                   config.SkipBacklog,
+		"emit-chunk-as-batch":            config.EmitChunkAsBatch,
+		"state-file":                     config.StateFile != "",
+		"chunk-order lifo":               config.ChunkOrder == "lifo",
+		"reject-sink":                    config.RejectSink != "",
+		"on-connection-close reconnect":  config.OnConnectionClose == closeReconnect,
+	} {
+		if set {
+			return nil, fmt.Errorf("%s can't apply to deliveries forwarded whole", option)
+		}
+	}
+
+	stopSignal, err := config.stopSignal()
+	if err != nil {
+		return nil, err
+	}
+
+	pollInterval, pollIntervalMax, err := config.pollIntervals()
+	if err != nil {
+		return nil, err
+	}
+
+	consumeArgs, err := config.consumeArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.QueueType == "stream" && !config.GetMode && (config.PrefetchCount == 0 || config.AutoAck) {
+		return nil, errors.New("consuming a stream queue requires prefetch-count and manual acks")
+	}
+
+	var tracer api.Tracer
+	if config.Tracer != "" {
+		if tracer, err = api.LookupTracer(config.Tracer); err != nil {
+			return nil, err
+		}
+	}
+
+	subs, err := subscribe(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(deliveries chan<- amqp091.Delivery, out chan<- error) {
+		errs, flush := bufferErrors(out, config.ErrorBufferSize, config.ErrorOverflow)
+		exit := &api.Exit{Reason: api.ExitError}
+		defer close(deliveries)
+		defer close(out)
+		defer func() { out <- exit }()
+		defer flush()
+		var merged <-chan amqp091.Delivery
+		defer func() {
+			for range merged {
+			}
+		}()
+
+		closings := make([]<-chan *amqp091.Error, len(subs))
+		cancellings := make([]<-chan string, len(subs))
+		for i, sub := range subs {
+			defer disconnect(sub.conn, sub.channel, errs)
+			closings[i] = sub.channel.NotifyClose(make(chan *amqp091.Error, config.NotifyCloseBuffer))
+			cancellings[i] = sub.channel.NotifyCancel(make(chan string, config.NotifyCancelBuffer))
+		}
+		closes := fanIn(len(subs)*config.NotifyCloseBuffer, closings...)
+		cancels := fanIn(len(subs)*config.NotifyCancelBuffer, cancellings...)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if stopSignal != nil {
+			ctx, cancel = signal.NotifyContext(ctx, stopSignal)
+			defer cancel()
+		}
+
+		sources := make([]<-chan amqp091.Delivery, len(subs))
+		for i, sub := range subs {
+			tag := ""
+			if config.ConnectionName != "" {
+				tag = config.consumerTag(sub.queue.Name)
+			}
+
+			if config.GetMode {
+				sources[i] = poll(ctx, sub.channel, sub.queue.Name, config.AutoAck, pollInterval, pollIntervalMax, errs)
+			} else if sources[i], err = sub.channel.Consume(sub.queue.Name, tag, config.AutoAck, false, false, config.NoWait, consumeArgs); err != nil {
+				cancel()
+				merged = fanIn(0, sources[:i]...)
+				exit.Err = err
+				return
+			}
+		}
+
+		merged = buffered(config.DeliveryBuffer, fanIn(0, sources...))
+		if config.GetMode {
+			defer func() {
+				cancel()
+				for range merged {
+				}
+			}()
+		}
+
+		for forwarded := 0; config.StopAfter == 0 || forwarded < config.StopAfter; forwarded++ {
+			select {
+			case msg, ok := <-merged:
+				if !ok {
+					switch {
+					case ctx.Err() != nil:
+						exit = &api.Exit{Reason: api.ExitCanceled}
+					case config.OnConnectionClose == closeStop:
+						exit = &api.Exit{Reason: api.ExitCanceled, Err: closeErr(closes, cancels)}
+					default:
+						exit = &api.Exit{Reason: api.ExitBrokerClosed, Err: closeErr(closes, cancels)}
+					}
+					return
+				}
+
+				// the span covers the hand off, the caller settling the delivery is beyond it
+				end := config.span(tracer, "amqp consume", config.consumed(&msg))
+				select {
+				case deliveries <- msg:
+					end(nil)
+				case <-ctx.Done():
+					end(ctx.Err())
+					// never handed over, so it's ours to requeue
+					if !config.AutoAck {
+						if err := msg.Nack(false, true); err != nil {
+							errs <- err
+						}
+					}
+					exit = &api.Exit{Reason: api.ExitCanceled}
+					return
+				}
+			case <-ctx.Done():
+				exit = &api.Exit{Reason: api.ExitCanceled}
+				return
+			}
+		}
+
+		exit = &api.Exit{Reason: api.ExitStopAfter}
+	}, nil
+}
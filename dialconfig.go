@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// dialConfig is what to dial uri with: dial-config if set, otherwise derived from the config
+func (config *queueConfig) dialConfig(uri string) (amqp091.Config, error) {
+	tlsConfig, err := config.tlsConfig(uri)
+	if err != nil {
+		return amqp091.Config{}, err
+	}
+
+	if config.DialConfig == "" {
+		return amqp091.Config{
+			Locale:          "en_US",
+			TLSClientConfig: tlsConfig,
+			Properties:      config.clientProperties(),
+		}, nil
+	}
+
+	dial, err := api.LookupDialConfig(config.DialConfig)
+	if err != nil {
+		return amqp091.Config{}, err
+	}
+
+	// the pins are only checked by the TLS config derived for them, they'd be silently dropped otherwise
+	if dial.TLSClientConfig == nil {
+		dial.TLSClientConfig = tlsConfig
+	} else if len(config.TLSPinnedCertSHA256) != 0 {
+		return amqp091.Config{}, fmt.Errorf("tls-pinned-cert-sha256 can't be checked by dial-config %q, which has its own TLSClientConfig", config.DialConfig)
+	} else {
+		dial.TLSClientConfig = dial.TLSClientConfig.Clone()
+	}
+
+	// the registered config is shared, each dial gets its own copy of what it may mutate,
+	// its own properties taking precedence over the derived ones
+	properties := config.clientProperties()
+	maps.Copy(properties, dial.Properties)
+	dial.Properties = properties
+	return dial, nil
+}
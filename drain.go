@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// drainTimeout is how long a cancelled producer keeps forwarding what was already delivered, 0 is not at all
+func (config *queueConfig) drainTimeout() (time.Duration, error) {
+	if config.DrainTimeout == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(config.DrainTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("drain-timeout: %w", err)
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("drain-timeout must be positive, got %s", timeout)
+	}
+
+	return timeout, nil
+}
+
+// consumerTag names the producer's consumer so drain can cancel it, and operators can tell it apart
+func (config *queueConfig) consumerTag(queue string) string {
+	return fmt.Sprintf("%s-%s-%d-%d", config.consumerTagPrefix(), queue, os.Getpid(), time.Now().UnixNano())
+}
+
+// drain cancels the consumer, so the broker delivers nothing more, then forwards and acks the deliveries
+// already prefetched until messages closes behind the last of them. Whatever is still buffered
+// after drain-timeout is nacked back onto the queue, and anything else unacked requeues as the channel closes
+func (p *producer) drain(messages <-chan amqp091.Delivery, closes <-chan *amqp091.Error, cancels <-chan string) *api.Exit {
+	if p.consumerTag != "" {
+		for _, channel := range p.channels {
+			if err := channel.Cancel(p.consumerTag, false); err != nil {
+				return &api.Exit{Reason: api.ExitError, Err: err}
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.drainTimeout)
+	defer cancel()
+	defer p.stopStream()
+
+	for !p.stopped() {
+		msgBuf, reason := p.fill(ctx, messages)
+		if err := p.forward(ctx, msgBuf); err != nil {
+			if p.config.Transactional {
+				p.channel.TxRollback()
+			}
+			return &api.Exit{Reason: api.ExitError, Err: err}
+		}
+
+		if err := p.commitOffset(false); err != nil {
+			return &api.Exit{Reason: api.ExitError, Err: err}
+		}
+
+		switch reason {
+		case api.ExitCanceled:
+			return &api.Exit{Reason: api.ExitCanceled, Err: p.requeueBuffered(messages)}
+		case api.ExitBrokerClosed:
+			if err := closeErr(closes, cancels); err != nil {
+				return &api.Exit{Reason: api.ExitBrokerClosed, Err: err}
+			}
+
+			return &api.Exit{Reason: api.ExitCanceled}
+		}
+	}
+
+	return &api.Exit{Reason: api.ExitStopAfter}
+}
+
+// requeueBuffered nacks with requeue every delivery messages has ready
+func (p *producer) requeueBuffered(messages <-chan amqp091.Delivery) error {
+	if p.config.AutoAck {
+		return nil
+	}
+
+	requeued := int64(0)
+	defer func() { count(p.config.Queue, "drain-requeued", requeued) }()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			if err := msg.Nack(false, true); err != nil {
+				return err
+			}
+			requeued++
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestDrainPartialChunk(t *testing.T) {
+	channel := newFakeChannel()
+	send := make(chan []byte, 1)
+	p := testProducer(&queueConfig{Queue: "q", ChunkSize: 3, DrainTimeout: "50ms"}, send)
+	p.drainTimeout = 50 * time.Millisecond
+	p.stopStream = func() {}
+
+	// two of the chunk's three deliveries were prefetched, and send only takes the first of them
+	messages := make(chan amqp091.Delivery, 2)
+	for _, msg := range testDeliveries(channel, 2) {
+		messages <- msg
+	}
+
+	exit := p.drain(messages, nil, nil)
+	if exit.Reason != api.ExitCanceled || exit.Err != nil {
+		t.Errorf("exited %v, want canceled", exit)
+	}
+
+	if len(send) != 1 {
+		t.Errorf("forwarded %d records, want 1", len(send))
+	}
+
+	channel.check(t, map[uint64]string{1: "acked", 2: "requeued"})
+}
@@ -0,0 +1,92 @@
+package main
+
+import "fmt"
+
+const (
+	overflowBlock      = "block"
+	overflowDropOldest = "drop-oldest"
+	overflowCoalesce   = "coalesce"
+)
+
+// coalesced stands in for errors that arrived while the error buffer was full
+type coalesced struct {
+	count int
+	last  error
+}
+
+func (err *coalesced) Error() string {
+	return fmt.Sprintf("%d errors coalesced while errs was backed up, the last: %v", err.count, err.last)
+}
+
+func (err *coalesced) Unwrap() error {
+	return err.last
+}
+
+// bufferErrors puts a buffer of size errors in front of out, so a slow reader of out doesn't
+// stall whatever is reporting errors. Once the buffer is full overflow decides what happens:
+// block waits for room, drop-oldest discards the oldest buffered error, coalesce folds new errors
+// into a single one counting them. The returned flush stops taking errors and waits for those
+// buffered to reach out, it must be called before out is closed. Without a size errors go straight to out
+func bufferErrors(out chan<- error, size int, overflow string) (chan<- error, func()) {
+	if size == 0 {
+		return out, func() {}
+	}
+
+	in, done := make(chan error), make(chan struct{})
+	go func() {
+		defer close(done)
+		intake := in
+		pending := make([]error, 0, size)
+		for intake != nil || len(pending) != 0 {
+			var send chan<- error
+			var next error
+			if len(pending) != 0 {
+				send, next = out, pending[0]
+			}
+
+			// blocking stops taking errors in while the buffer is full
+			recv := intake
+			if overflow == overflowBlock && len(pending) == size {
+				recv = nil
+			}
+
+			select {
+			case err, ok := <-recv:
+				if !ok {
+					intake = nil
+					continue
+				}
+
+				pending = admit(pending, err, size, overflow)
+			case send <- next:
+				pending = pending[1:]
+			}
+		}
+	}()
+
+	return in, func() {
+		close(in)
+		<-done
+	}
+}
+
+func admit(pending []error, err error, size int, overflow string) []error {
+	if len(pending) < size {
+		return append(pending, err)
+	}
+
+	switch overflow {
+	case overflowDropOldest:
+		return append(pending[1:], err)
+	case overflowCoalesce:
+		last := pending[len(pending)-1]
+		if merged, ok := last.(*coalesced); ok {
+			merged.count++
+			merged.last = err
+		} else {
+			pending[len(pending)-1] = &coalesced{count: 2, last: err}
+		}
+	}
+
+	return pending
+}
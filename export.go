@@ -1,54 +1,10 @@
 package main
 
 import (
-	"context"
-
 	"github.com/psyduck-etl/sdk"
-	"github.com/rabbitmq/amqp091-go"
 	"github.com/zclconf/go-cty/cty"
 )
 
-type queueConfig struct {
-	Connection  string `cty:"connection"`
-	Queue       string `cty:"queue"`
-	ContentType string `cty:"content-type"`
-	StopAfter   int    `cty:"stop-after"`
-	ChunkSize   uint   `cty:"chunk-size"`
-	NoWait      bool   `cty:"no-wait"`
-	AutoAck     bool   `cty:"auto-ack"`
-}
-
-func connect(config *queueConfig) (*amqp091.Connection, *amqp091.Channel, amqp091.Queue, error) {
-	conn, err := amqp091.Dial(config.Connection)
-	if err != nil {
-		return nil, nil, amqp091.Queue{}, err
-	}
-
-	channel, err := conn.Channel()
-	if err != nil {
-		return nil, nil, amqp091.Queue{}, err
-	}
-
-	// TODO sdk should support an object, where we would have queue declare options set
-	// but for now, just defaults
-	queue, err := channel.QueueDeclare(config.Queue, false, false, false, false, nil)
-	if err != nil {
-		return nil, nil, amqp091.Queue{}, err
-	}
-
-	return conn, channel, queue, nil
-}
-
-func disconnect(conn *amqp091.Connection, channel *amqp091.Channel, errs chan<- error) {
-	if err := conn.Close(); err != nil {
-		errs <- err
-	}
-
-	if err := channel.Close(); err != nil {
-		errs <- err
-	}
-}
-
 func Plugin() *sdk.Plugin {
 	return &sdk.Plugin{
 		Name: "amqp",
@@ -59,9 +15,10 @@ func Plugin() *sdk.Plugin {
 				Spec: []*sdk.Spec{
 					{
 						Name:        "connection",
-						Description: "AMQP broker server connection string - amqp://{user}:{password}@{hostname}:{port}",
-						Required:    true,
+						Description: "AMQP broker server connection string - amqp://{user}:{password}@{hostname}:{port}, required unless connections is set",
+						Required:    false,
 						Type:        cty.String,
+						Default:     cty.StringVal(""),
 					},
 					{
 						Name:        "queue",
@@ -104,75 +61,685 @@ func Plugin() *sdk.Plugin {
 						Type:        cty.Bool,
 						Default:     cty.BoolVal(false),
 					},
+					{
+						Name:        "exchange",
+						Description: "Exchange to bind the queue to and publish through, the default exchange if empty",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "exchange-type",
+						Description: "Type of the exchange, or with delayed-exchange the type it routes as (x-delayed-type)",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("direct"),
+					},
+					{
+						Name:        "routing-key",
+						Description: "Routing key to bind and publish with, the queue name if empty",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "delayed-exchange",
+						Description: "Declare the exchange as x-delayed-message, requires the rabbitmq_delayed_message_exchange plugin",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "delay",
+						Description: "Milliseconds the delayed exchange holds each published message for (x-delay)",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "state-file",
+						Description: "File persisting how many messages have been forwarded, so stop-after resumes across restarts (at-least-once)",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "drop-filter",
+						Description: "Regular expressions, messages whose body matches any are dropped instead of published",
+						Required:    false,
+						Type:        cty.List(cty.String),
+						Default:     cty.ListValEmpty(cty.String),
+					},
+					{
+						Name:        "queue-prefix",
+						Description: "Prefix prepended to the queue and exchange names, namespacing tenants on a shared broker",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "reliability",
+						Description: "at-most-once (auto-ack, fire-and-forget publish) or at-least-once (ack after forwarding, confirm and mandatory publish), overrides auto-ack, confirm and mandatory",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "confirm",
+						Description: "Put the publishing channel in confirm mode, reporting messages the broker nacks",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "mandatory",
+						Description: "Publish as mandatory, reporting messages the broker returns as unroutable",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "confirm-callback",
+						Description: "Name a callback was registered under with api.RegisterConfirmCallback, told the outcome of every confirmed publish",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "decompress",
+						Description: "Codec decoding each consumed message before any codecs, kept for compatibility with codecs = [..., decompress]",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "framing",
+						Description: "Split each consumed (and decoded) message into records, one of length-prefixed (big endian uint32)",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "codecs",
+						Description: "Codecs in the order bodies are encoded, published messages are encoded first to last and consumed ones decoded last to first, gzip, base64 or any registered with api.RegisterCodec",
+						Required:    false,
+						Type:        cty.List(cty.String),
+						Default:     cty.ListValEmpty(cty.String),
+					},
+					{
+						Name:        "priority",
+						Description: "Priority to publish with, 0-255",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "priority-json-path",
+						Description: "Dotted path to a number in each JSON body to publish with as its priority, clamped to 0-255, priority is used when it can't be read",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "max-publish-rate",
+						Description: "Maximum messages published per second, reading from the pipeline blocks while exceeded, 0 is unlimited",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "publish-burst",
+						Description: "Messages that may be published back to back before max-publish-rate applies",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(1),
+					},
+					{
+						Name:        "max-consume-rate",
+						Description: "Maximum messages forwarded per second, messages are acked as they are forwarded while set, 0 is unlimited",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "consume-burst",
+						Description: "Messages that may be forwarded back to back before max-consume-rate applies",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(1),
+					},
+					{
+						Name:        "topology-file",
+						Description: "JSON file of exchanges, queues and bindings to declare before the queue, in that order",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "reconcile-topology",
+						Description: "Correct queues and exchanges that exist with different arguments than declared by deleting and redeclaring them, logging every correction. A queue or exchange another config in this process has declared differently is refused before reaching the broker either way",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "allow-destructive",
+						Description: "Confirm reconcile-topology may delete queues and exchanges, along with the messages and bindings they hold",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "stop-signal",
+						Description: "Signal (SIGHUP, SIGINT, SIGTERM, SIGUSR1 or SIGUSR2) on which the producer stops filling its current chunk, forwards and acks as much of it as is taken without blocking, requeues the rest and exits",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "queue-type",
+						Description: "Type to declare the queue as (x-queue-type), one of classic, quorum or stream, the broker default if empty",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "durable",
+						Description: "Declare the queue as durable, required by quorum and stream queues",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "single-active-consumer",
+						Description: "Declare the queue with x-single-active-consumer, so one consumer across all connections receives at a time and the next takes over should it go away",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "sample-rate",
+						Description: "Fraction of consumed messages to forward, evenly spaced, 0.1 forwards 1 in 10. Sampled out messages are acked, removing them from the queue",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(1),
+					},
+					{
+						Name:        "sample-requeue",
+						Description: "Requeue sampled out messages instead of acking them, leaving them for other consumers. Requires sample-requeue-shared",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "sample-requeue-shared",
+						Description: "Confirm that other consumers share the queue sample-requeue puts messages back on: consumed alone, each requeued message comes straight back until it's sampled in, so everything is forwarded after all",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "get-mode",
+						Description: "Poll the queue with basic.get instead of subscribing with basic.consume",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "poll-interval",
+						Description: "Wait after a get-mode poll finds the queue empty, polls are back to back while messages flow",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("1s"),
+					},
+					{
+						Name:        "poll-interval-max",
+						Description: "Upper bound the wait doubles toward with each consecutive empty poll, a fixed poll-interval if empty",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "correlation-id",
+						Description: "Correlation id to publish with, takes precedence over correlation-id-from-content",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "correlation-id-from-content",
+						Description: "Publish with the hex SHA-256 of the body as the correlation id, so identical payloads share one",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "correlation-id-json-path",
+						Description: "Dotted path to the JSON field hashed for correlation-id-from-content instead of the whole body",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "tls-pinned-cert-sha256",
+						Description: "Hex SHA-256 fingerprints the broker's certificate must match one of, on top of CA validation, list several to rotate",
+						Required:    false,
+						Type:        cty.List(cty.String),
+						Default:     cty.ListValEmpty(cty.String),
+					},
+					{
+						Name:        "notify-close-buffer",
+						Description: "Buffer of the channel close notification",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(1),
+					},
+					{
+						Name:        "notify-cancel-buffer",
+						Description: "Buffer of the consumer cancel notification",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(1),
+					},
+					{
+						Name:        "notify-return-buffer",
+						Description: "Buffer of mandatory returns, the channel stalls while it's full",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(64),
+					},
+					{
+						Name:        "notify-publish-buffer",
+						Description: "Publishes awaiting their confirm before publishing blocks",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(256),
+					},
+					{
+						Name:        "prefetch-count",
+						Description: "Unacked messages the broker delivers ahead (basic.qos), unlimited if 0, stream queues require it. With manual acks it must hold a whole chunk-size, plus any reorder-window",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "stream-offset",
+						Description: "Where to start consuming a stream queue: first, last, next, an offset, or an RFC3339 timestamp such as 2024-01-02T15:04:05Z",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "ack-signal",
+						Description: "Name of the api.Completions back channel the pipeline signals processed records on, messages are only acked once all their records are signalled",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "ack-timeout",
+						Description: "How long after forwarding a message it may wait on ack-signal before being requeued",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("30s"),
+					},
+					{
+						Name:        "queue-name-json-path",
+						Description: "Dotted path to a queue name in each JSON body to publish it to through the default exchange, declaring the queue like the configured one on first use, messages naming none are published as usual",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "transactional",
+						Description: "Ack each chunk in a channel transaction committed once the whole chunk is forwarded, and rolled back (redelivering the chunk) otherwise. Costs a round trip per chunk, and prefetch-count must allow a whole chunk",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "dedup-chunk-by-header",
+						Description: "Header whose value is forwarded once per chunk, later messages in the chunk repeating it are acked without forwarding, removing them from the queue",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "error-buffer-size",
+						Description: "Errors buffered ahead of the errs channel so a slow reader doesn't stall processing, unbuffered if 0",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "error-overflow",
+						Description: "What a full error buffer does with another error: block until there's room, drop-oldest, or coalesce it with the rest into one counting them",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("block"),
+					},
+					{
+						Name:        "reject-sink",
+						Description: "Queue that messages rejected without requeue are republished to with their x-reject-reason and x-reject-error headers, instead of being nacked",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "reorder-header",
+						Description: "Integer header numbering messages, consumed messages are held back to forward them in its order, each is then acked alone",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "reorder-window",
+						Description: "Most messages held back by reorder-header, a full window forwards past the gap it waits on. Larger windows survive more disorder at the cost of memory and prefetch-count must allow them",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(100),
+					},
+					{
+						Name:        "reorder-gap-timeout",
+						Description: "Longest a held back message waits on a missing sequence number before the gap is skipped, bounding the latency reordering adds",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("1s"),
+					},
+					{
+						Name:        "max-length",
+						Description: "Most messages the queue is declared to hold (x-max-length), 0 is unbounded",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "overflow",
+						Description: "What the queue does with messages beyond max-length (x-overflow): drop-head, reject-publish, or reject-publish-dlx to dead letter them instead. Quorum queues don't support reject-publish-dlx",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "dead-letter-exchange",
+						Description: "Exchange the queue dead letters messages to (x-dead-letter-exchange), namespaced by queue-prefix",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "dead-letter-routing-key",
+						Description: "Routing key dead lettered messages are republished with (x-dead-letter-routing-key), their own by default",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "chunk-order",
+						Description: "Order each chunk is forwarded in once filled: fifo, or lifo for newest first. Only reorders within a chunk, a lifo chunk's messages are acked one by one",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("fifo"),
+					},
+					{
+						Name:        "selector",
+						Description: "Expression consumed messages must match to be forwarded, others are acked and skipped. Compares properties (priority, type, message-id, routing-key...) or else headers with = != < <= > >=, 'strings', numbers and TRUE/FALSE, combined with AND, OR, NOT, parentheses and IS [NOT] NULL, e.g. priority > 5 AND type = 'order'",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "keepalive-interval",
+						Description: "How long the consumer may receive nothing before publishing keepalive-payload with an x-keepalive header, so downstream can tell the pipeline is alive, empty is never",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "keepalive-payload",
+						Description: "Body of each keepalive message",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "keepalive-queue",
+						Description: "Queue keepalives are published to, declared like reject-sink, empty publishes them where messages go",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "drain-timeout",
+						Description: "How long a cancelled producer stops consuming but keeps forwarding and acking the messages already prefetched, before requeueing what's left and disconnecting. Empty exits straight away, leaving unacked messages to requeue on disconnect",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "bridge",
+						Description: "Carry each message between an amqp-queue producer and consumer as a JSON envelope of its body and content type, for republishing from one broker or queue to another",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "preserve-content-type",
+						Description: "Republish bridged messages with their original content type rather than content-type, which still applies to those that had none",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(true),
+					},
+					{
+						Name:        "connections",
+						Description: "Further broker connection strings, such as each node of a cluster, tried after connection until one accepts. Each connect starts after the broker last connected to, rotating through the list as it reconnects",
+						Required:    false,
+						Type:        cty.List(cty.String),
+						Default:     cty.ListValEmpty(cty.String),
+					},
+					{
+						Name:        "connection-order",
+						Description: "Order connection and connections are tried in: ordered, rotating from the last one connected to, or random for a fresh shuffle on each connect",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("ordered"),
+					},
+					{
+						Name:        "delay-queue",
+						Description: "Queue the consumer publishes to instead, declared with delay-queue-ttl as its x-message-ttl and dead lettering expired messages on to the exchange with routing-key or else the queue, delaying each by the ttl",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "delay-queue-ttl",
+						Description: "How long messages wait in delay-queue, at millisecond precision",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "sources",
+						Description: "Connection and queue pairs, such as one per tenant vhost, a producer consumes from in place of connection and queue, merging their messages and acking each on its own channel. Every source costs a connection, a channel and up to prefetch-count buffered messages, queue still names its metrics",
+						Required:    false,
+						Type:        cty.List(cty.Object(map[string]cty.Type{"connection": cty.String, "queue": cty.String})),
+						Default:     cty.ListValEmpty(cty.Object(map[string]cty.Type{"connection": cty.String, "queue": cty.String})),
+					},
+					{
+						Name:        "message-id",
+						Description: "Publish each message with a message id made by message-id-scheme",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "message-id-scheme",
+						Description: "How message-id makes ids: uuidv4 (random), uuidv7 (random but sorting by the millisecond made), ksuid (27 base62 characters sorting by the second made), content (hex SHA-256 of the body, repeating for repeated bodies), or the name of a generator registered with api.RegisterIDGenerator",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("uuidv4"),
+					},
+					{
+						Name:        "offset-store",
+						Description: "File committing the offset after the last message forwarded from a stream queue, consumption resumes from it on restart in place of stream-offset",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "offset-commit-interval",
+						Description: "Most time between commits to offset-store, checked after each chunk and committed again on exit. Messages forwarded since the last commit are forwarded again after a crash (at-least-once), a longer interval repeats more but writes less, 0 commits every chunk",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("5s"),
+					},
+					{
+						Name:        "dial-config",
+						Description: "Name of an amqp091.Config registered with api.RegisterDialConfig to dial with as is, taking precedence over the connection settings derived from config: only its TLSClientConfig, when nil, is still derived from the connection URI and tls-pinned-cert-sha256, which can't be set along with a dial-config having its own",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "skip-backlog",
+						Description: "Only forward messages arriving after the producer connects, leaving out those already queued as skip-backlog-strategy says",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "skip-backlog-strategy",
+						Description: "How skip-backlog leaves out the backlog: purge deletes it from the queue for every consumer (destructive), skip consumes a stream from next without touching it, and is only supported by streams",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("skip"),
+					},
+					{
+						Name:        "tracer",
+						Description: "Name of an api.Tracer registered with api.RegisterTracer starting an amqp consume span for each message consumed and an amqp publish span for each published",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "trace-attributes",
+						Description: "Properties each span is given as attributes, of queue, exchange, routing-key, message-id, correlation-id, redelivered, content-type, priority and body-size. Ids are unique per message, so only add them where trace storage can bear the cardinality",
+						Required:    false,
+						Type:        cty.List(cty.String),
+						Default:     cty.ListVal([]cty.Value{cty.StringVal("queue"), cty.StringVal("exchange"), cty.StringVal("routing-key"), cty.StringVal("redelivered")}),
+					},
+					{
+						Name:        "on-connection-close",
+						Description: "What a producer does when the broker closes its connection or channel: stop exits cleanly as if cancelled, error exits with the broker's error for the pipeline to handle, reconnect connects again (rotating through connections) and goes on producing, unacked messages having been requeued and a stream resuming after the last message forwarded",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("error"),
+					},
+					{
+						Name:        "reconnect-interval",
+						Description: "How long on-connection-close reconnect waits before each attempt to connect again",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("1s"),
+					},
+					{
+						Name:        "connection-name",
+						Description: "Name the connection advertises, shown for it in the management UI and broker logs, and leading the tags of the producer's consumers listed on its channels",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal(""),
+					},
+					{
+						Name:        "connection-tags",
+						Description: "key=value client properties the connection advertises, such as pipeline=orders, listed among its client properties in the management UI alongside an instance property of the host and pid",
+						Required:    false,
+						Type:        cty.List(cty.String),
+						Default:     cty.ListValEmpty(cty.String),
+					},
+					{
+						Name:        "delivery-buffer",
+						Description: "Deliveries the producer buffers ahead of filling chunks, smoothing bursts. prefetch-count is what bounds deliveries in flight, the client already queues those in memory however many there are, so size prefetch-count for the burst and the buffer within it, 0 hands each over as it's asked for",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "emit-chunk-as-batch",
+						Description: "Forward the records of each chunk combined as batch-format into one body, for sinks writing in bulk, acking the chunk once the batch is forwarded. stop-after still counts records, cutting the last batch short at it",
+						Required:    false,
+						Type:        cty.Bool,
+						Default:     cty.BoolVal(false),
+					},
+					{
+						Name:        "batch-format",
+						Description: "How emit-chunk-as-batch combines records: concat, newline terminating each, or json-array, rejecting records that aren't JSON",
+						Required:    false,
+						Type:        cty.String,
+						Default:     cty.StringVal("newline"),
+					},
 				},
 				ProvideProducer: func(parse sdk.Parser) (sdk.Producer, error) {
-					config := new(queueConfig)
-					if err := parse(config); err != nil {
-						return nil, err
-					}
-
-					conn, channel, queue, err := connect(config)
+					config, err := parseConfig(parse)
 					if err != nil {
 						return nil, err
 					}
 
-					// TODO if we encounter an err before we return data, errs, the function will deadlock if errs is unbuffered
-					return func(send chan<- []byte, errs chan<- error) {
-						messages, err := channel.Consume(queue.Name, "", config.AutoAck, false, false, config.NoWait, nil)
-						if err != nil {
-							errs <- err
-						}
-
-						iters := 0
-						defer close(send)
-						defer close(errs)
-						defer disconnect(conn, channel, errs)
-
-						for {
-							msgBuf := make([]amqp091.Delivery, config.ChunkSize)
-							for i := uint(0); i < config.ChunkSize; i++ {
-								msgBuf[i] = <-messages
-							}
-							if !config.AutoAck {
-								if err := msgBuf[len(msgBuf)-1].Ack(true); err != nil {
-									errs <- err
-									return
-								}
-							}
-							for _, msg := range msgBuf {
-								send <- msg.Body
-								iters++
-								if config.StopAfter != 0 && iters >= config.StopAfter {
-									return
-								}
-							}
-						}
-					}, nil
+					return produce(config)
 				},
 				ProvideConsumer: func(parse sdk.Parser) (sdk.Consumer, error) {
-					config := new(queueConfig)
-					if err := parse(config); err != nil {
+					config, err := parseConfig(parse)
+					if err != nil {
 						return nil, err
 					}
 
-					conn, channel, queue, err := connect(config)
+					return consume(config)
+				},
+			},
+			{
+				Kinds: sdk.PRODUCER,
+				Name:  "amqp-dlx-replay",
+				Spec: []*sdk.Spec{
+					{
+						Name:        "connection",
+						Description: "AMQP broker server connection string - amqp://{user}:{password}@{hostname}:{port}",
+						Required:    true,
+						Type:        cty.String,
+					},
+					{
+						Name:        "queue",
+						Description: "Dead letter queue to replay, republishing each message to the exchange and routing key of its latest x-death entry and sending its body on",
+						Required:    true,
+						Type:        cty.String,
+					},
+					{
+						Name:        "replay-rate",
+						Description: "Maximum messages replayed per second, 0 is unlimited",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+					{
+						Name:        "replay-burst",
+						Description: "Messages that may be replayed back to back before replay-rate applies",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(1),
+					},
+					{
+						Name:        "replay-count",
+						Description: "Most messages to replay, 0 replays until the queue is empty",
+						Required:    false,
+						Type:        cty.Number,
+						Default:     cty.NumberIntVal(0),
+					},
+				},
+				ProvideProducer: func(parse sdk.Parser) (sdk.Producer, error) {
+					config, err := parseReplayConfig(parse)
 					if err != nil {
 						return nil, err
 					}
 
-					return func(recv <-chan []byte, errs chan<- error, done chan<- struct{}) {
-						defer close(done)
-						defer close(errs)
-						defer disconnect(conn, channel, errs)
-						for d := range recv {
-							if err := channel.PublishWithContext(context.Background(), "", queue.Name, false, false, amqp091.Publishing{
-								ContentType: config.ContentType,
-								Body:        d,
-							}); err != nil {
-								errs <- err
-							}
-						}
-					}, nil
+					return produceReplay(config)
 				},
 			},
 		},
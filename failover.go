@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// rotations remembers, per list of brokers, the index the next ordered connect starts from
+var rotations = struct {
+	sync.Mutex
+	next map[string]int
+}{next: make(map[string]int)}
+
+// brokers are connection followed by connections
+func (config *queueConfig) brokers() []string {
+	brokers := make([]string, 0, len(config.Connections)+1)
+	if config.Connection != "" {
+		brokers = append(brokers, config.Connection)
+	}
+
+	return append(brokers, config.Connections...)
+}
+
+// dial connects to the first broker that accepts, trying them in order starting after the one last
+// connected to, so each reconnection rotates through the cluster, or in a fresh shuffle with
+// connection-order random, returning the connection with the URI it was dialed on. If none accepts their errors are joined
+func (config *queueConfig) dial() (*amqp091.Connection, string, error) {
+	brokers := config.brokers()
+	if len(brokers) == 0 {
+		return nil, "", errors.New("no connection configured, sources are only consumed by a producer")
+	}
+
+	key := strings.Join(brokers, "\n")
+
+	order := make([]int, len(brokers))
+	if config.ConnectionOrder == "random" {
+		order = rand.Perm(len(brokers))
+	} else {
+		rotations.Lock()
+		start := rotations.next[key]
+		rotations.Unlock()
+
+		for i := range order {
+			order[i] = (start + i) % len(brokers)
+		}
+	}
+
+	failures := make([]error, 0, len(brokers))
+	for _, i := range order {
+		dial, err := config.dialConfig(brokers[i])
+		if err != nil {
+			return nil, "", err
+		}
+
+		conn, err := amqp091.DialConfig(brokers[i], dial)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", host(brokers[i]), err))
+			continue
+		}
+
+		rotations.Lock()
+		rotations.next[key] = (i + 1) % len(brokers)
+		rotations.Unlock()
+		return conn, brokers[i], nil
+	}
+
+	return nil, "", errors.Join(failures...)
+}
+
+// host is the host and port of a broker URI, for errors that mustn't leak its credentials
+func host(uri string) string {
+	parsed, err := amqp091.ParseURI(uri)
+	if err != nil {
+		return "unparseable connection"
+	}
+
+	return fmt.Sprintf("%s:%d", parsed.Host, parsed.Port)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// lookupJSON resolves a dotted path (such as "order.priority" or "items.0.id") against a JSON body
+func lookupJSON(body []byte, path string) (any, bool) {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, false
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+
+			value = next
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
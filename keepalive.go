@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// keepaliveInterval is how long the consumer may go without input before publishing a keepalive, 0 is never
+func (config *queueConfig) keepaliveInterval() (time.Duration, error) {
+	if config.KeepaliveInterval == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(config.KeepaliveInterval)
+	if err != nil {
+		return 0, fmt.Errorf("keepalive-interval: %w", err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("keepalive-interval must be positive, got %s", interval)
+	}
+
+	return interval, nil
+}
+
+// restart resets timer to fire after interval, discarding a firing not yet received
+func restart(timer *time.Timer, interval time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+
+	timer.Reset(interval)
+}
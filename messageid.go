@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/psyduck-std/amqp/api"
+)
+
+func init() {
+	api.RegisterIDGenerator("uuidv4", api.IDGeneratorFunc(uuidV4))
+	api.RegisterIDGenerator("uuidv7", api.IDGeneratorFunc(uuidV7))
+	api.RegisterIDGenerator("ksuid", api.IDGeneratorFunc(ksuid))
+	api.RegisterIDGenerator("content", api.IDGeneratorFunc(contentID))
+}
+
+func formatUUID(id [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// uuidV4 is a random UUID
+func uuidV4([]byte) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id), nil
+}
+
+// uuidV7 is a UUID led by the unix time in milliseconds, so ids sort by when they were made
+func uuidV7([]byte) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+
+	var millis [8]byte
+	binary.BigEndian.PutUint64(millis[:], uint64(time.Now().UnixMilli()))
+	copy(id[:6], millis[2:])
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id), nil
+}
+
+const (
+	ksuidEpoch    = 1400000000
+	ksuidAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// ksuid is a KSUID: 27 base62 characters of a seconds timestamp then 128 random bits, sorting by time
+func ksuid([]byte) (string, error) {
+	var id [20]byte
+	binary.BigEndian.PutUint32(id[:4], uint32(time.Now().Unix()-ksuidEpoch))
+	if _, err := rand.Read(id[4:]); err != nil {
+		return "", err
+	}
+
+	encoded := make([]byte, 27)
+	n, base, digit := new(big.Int).SetBytes(id[:]), big.NewInt(62), new(big.Int)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		n.DivMod(n, base, digit)
+		encoded[i] = ksuidAlphabet[digit.Int64()]
+	}
+
+	return string(encoded), nil
+}
+
+// contentID is the hex SHA-256 of the body, so republishing the same body repeats its id
+func contentID(body []byte) (string, error) {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,11 @@
+package main
+
+import "expvar"
+
+// metrics are published through expvar (/debug/vars when the host serves it) under "amqp",
+// keyed "{queue}.{counter}"
+var metrics = expvar.NewMap("amqp")
+
+func count(queue, counter string, delta int64) {
+	metrics.Add(queue+"."+counter, delta)
+}
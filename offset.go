@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// offsetCommitInterval is the most time between commits to offset-store
+func (config *queueConfig) offsetCommitInterval() (time.Duration, error) {
+	interval, err := time.ParseDuration(config.OffsetCommitInterval)
+	if err != nil {
+		return 0, fmt.Errorf("offset-commit-interval: %w", err)
+	}
+
+	if interval < 0 {
+		return 0, fmt.Errorf("offset-commit-interval must not be negative, got %s", interval)
+	}
+
+	return interval, nil
+}
+
+// loadOffset reads the stream offset to resume from committed at path, if any has been
+func loadOffset(path string) (int64, bool, error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	}
+
+	next, err := readCount(path)
+	if err != nil {
+		return 0, false, fmt.Errorf("offset-store %s: %w", path, err)
+	}
+
+	return int64(next), true, nil
+}
+
+// delivered notes the stream offset of a forwarded delivery, committing from then on past it
+func (p *producer) delivered(offset any) {
+	if n, ok := headerInt(offset); ok && n+1 > p.offset {
+		p.offset = n + 1
+	}
+}
+
+// resumeArgs are the consume args of a session: once deliveries have been forwarded from a stream,
+// a reconnected session resumes after the last of them rather than from where the producer started
+func (p *producer) resumeArgs() amqp091.Table {
+	// sources each have their own offset
+	if p.offset == 0 || len(p.config.Sources) != 0 {
+		return p.consumeArgs
+	}
+
+	args := maps.Clone(p.consumeArgs)
+	args["x-stream-offset"] = p.offset
+	return args
+}
+
+// commitOffset commits the offset after the latest forwarded delivery to offset-store, at most
+// each offset-commit-interval unless forced. Deliveries forwarded since the last commit are
+// forwarded again on restart: at-least-once, with more repeated the longer the interval
+func (p *producer) commitOffset(force bool) error {
+	if p.config.OffsetStore == "" || p.offset == p.committed {
+		return nil
+	}
+
+	if !force && time.Since(p.committedAt) < p.commitInterval {
+		return nil
+	}
+
+	if err := writeCount(p.config.OffsetStore, int(p.offset)); err != nil {
+		return fmt.Errorf("offset-store %s: %w", p.config.OffsetStore, err)
+	}
+
+	p.committed, p.committedAt = p.offset, time.Now()
+	return nil
+}
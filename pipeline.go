@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/psyduck-std/amqp/api"
+)
+
+// stage unpacks one body into the records it carries
+type stage func(body []byte) ([][]byte, error)
+
+// decoder is the stage undoing codec
+func decoder(codec api.Codec) stage {
+	return func(body []byte) ([][]byte, error) {
+		decoded, err := codec.Decode(body)
+		if err != nil {
+			return nil, err
+		}
+
+		return [][]byte{decoded}, nil
+	}
+}
+
+// deframe splits a body of records each prefixed by their length as a big endian uint32
+func deframe(body []byte) ([][]byte, error) {
+	records := make([][]byte, 0)
+	for len(body) != 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("truncated frame header, %d trailing bytes", len(body))
+		}
+
+		size := binary.BigEndian.Uint32(body)
+		body = body[4:]
+		if uint64(len(body)) < uint64(size) {
+			return nil, fmt.Errorf("truncated frame, want %d bytes but have %d", size, len(body))
+		}
+
+		records = append(records, body[:size])
+		body = body[size:]
+	}
+
+	return records, nil
+}
+
+// pipeline composes the configured stages: decompress, then codecs decoded last to first, then framing
+func (config *queueConfig) pipeline() ([]stage, error) {
+	names := config.Codecs
+	if config.Decompress != "" {
+		names = append(names[:len(names):len(names)], config.Decompress)
+	}
+
+	chain, err := codecChain(names)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make([]stage, 0, len(chain)+1)
+	for i := len(chain) - 1; i >= 0; i-- {
+		stages = append(stages, decoder(chain[i]))
+	}
+
+	switch config.Framing {
+	case "":
+	case "length-prefixed":
+		stages = append(stages, deframe)
+	default:
+		return nil, fmt.Errorf("unsupported framing %q", config.Framing)
+	}
+
+	return stages, nil
+}
+
+// unpack runs body through each stage in turn, feeding every record a stage yields to the next
+func unpack(stages []stage, body []byte) ([][]byte, error) {
+	records := [][]byte{body}
+	for _, next := range stages {
+		unpacked := make([][]byte, 0, len(records))
+		for _, record := range records {
+			out, err := next(record)
+			if err != nil {
+				return nil, err
+			}
+
+			unpacked = append(unpacked, out...)
+		}
+
+		records = unpacked
+	}
+
+	return records, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, body []byte) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(body); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// framed prefixes each record with its length
+func framed(records ...string) []byte {
+	var body []byte
+	for _, record := range records {
+		body = binary.BigEndian.AppendUint32(body, uint32(len(record)))
+		body = append(body, record...)
+	}
+
+	return body
+}
+
+func TestUnpackGzippedFrames(t *testing.T) {
+	stages, err := (&queueConfig{Decompress: "gzip", Framing: "length-prefixed"}).pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		body []byte
+		want []string
+		// err is part of the error wanted, if any
+		err string
+	}{
+		{name: "frames", body: gzipped(t, framed("a", "", "bcd")), want: []string{"a", "", "bcd"}},
+		{name: "empty payload", body: gzipped(t, nil), want: []string{}},
+		{name: "empty body", body: nil, err: "EOF"},
+		{name: "not gzip", body: []byte("not a gzip stream"), err: "invalid header"},
+		{name: "truncated header", body: gzipped(t, append(framed("a"), 0, 0, 1)), err: "truncated frame header, 3 trailing bytes"},
+		{name: "truncated frame", body: gzipped(t, framed("a", "bcd")[:11]), err: "truncated frame, want 3 bytes but have 2"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := unpack(stages, test.body)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("unpack error %v, want %q", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(records))
+			for i, record := range records {
+				got[i] = string(record)
+			}
+
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.want) {
+				t.Errorf("unpacked %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// pollIntervals parses poll-interval and poll-interval-max, without a max the interval is fixed
+func (config *queueConfig) pollIntervals() (time.Duration, time.Duration, error) {
+	interval, err := time.ParseDuration(config.PollInterval)
+	if err != nil {
+		return 0, 0, fmt.Errorf("poll-interval: %w", err)
+	}
+
+	if interval <= 0 {
+		return 0, 0, fmt.Errorf("poll-interval must be positive, got %s", interval)
+	}
+
+	if config.PollIntervalMax == "" {
+		return interval, interval, nil
+	}
+
+	maxInterval, err := time.ParseDuration(config.PollIntervalMax)
+	if err != nil {
+		return 0, 0, fmt.Errorf("poll-interval-max: %w", err)
+	}
+
+	if maxInterval < interval {
+		return 0, 0, fmt.Errorf("poll-interval-max %s is shorter than poll-interval %s", maxInterval, interval)
+	}
+
+	return interval, maxInterval, nil
+}
+
+// poll feeds deliveries fetched with basic.get into the returned channel until ctx is done or a get fails.
+// While messages are flowing the next get is immediate, each get finding the queue empty
+// doubles the wait before the next from interval up to maxInterval
+func poll(ctx context.Context, channel *amqp091.Channel, queue string, autoAck bool, interval, maxInterval time.Duration, errs chan<- error) <-chan amqp091.Delivery {
+	messages := make(chan amqp091.Delivery)
+	go func() {
+		defer close(messages)
+		wait := interval
+		for {
+			msg, ok, err := channel.Get(queue, autoAck)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if ok {
+				wait = interval
+				select {
+				case messages <- msg:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+
+			wait = min(wait*2, maxInterval)
+		}
+	}()
+
+	return messages
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/signal"
+	"slices"
+	"time"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type producer struct {
+	config   *queueConfig
+	channel  *amqp091.Channel
+	stages   []stage
+	throttle *limiter
+	selector selector
+	tracer   api.Tracer
+	// batch combines each chunk's records into one body with emit-chunk-as-batch
+	batch *batch
+	// ackAfterSend acks each chunk as far as it was forwarded rather than as soon as it's filled,
+	// see acksAfterForward
+	ackAfterSend bool
+	// deliveries reordered by reorder-header or a lifo chunk-order are forwarded
+	// out of delivery tag order, so each is acked alone
+	reordered bool
+
+	send  chan<- []byte
+	errs  chan<- error
+	iters int
+	// sampleCredit accrues sample-rate per message, a message is forwarded each time it reaches 1
+	sampleCredit float64
+	// forwarded are the deliveries whose records were all sent since the last ack,
+	// acking the latest acks the chunk so far unless they were reordered
+	forwarded []*amqp091.Delivery
+	// handoffs waits on ack-signal to ack each delivery in place of forwarded
+	handoffs   chan handoff
+	ackTimeout time.Duration
+
+	// consumerTag, drainTimeout and stopStream drain the consumer on each of channels once cancelled
+	channels     []*amqp091.Channel
+	consumerTag  string
+	drainTimeout time.Duration
+	stopStream   context.CancelFunc
+
+	// offset follows the deliveries forwarded from a stream, resumed from on reconnecting
+	// and committed to offset-store
+	offset         int64
+	committed      int64
+	committedAt    time.Time
+	commitInterval time.Duration
+
+	// each session consumes with these
+	consumeArgs       amqp091.Table
+	pollInterval      time.Duration
+	pollIntervalMax   time.Duration
+	reorderGapTimeout time.Duration
+	reconnectInterval time.Duration
+	// merged feeds the session the deliveries of every subscription
+	merged <-chan amqp091.Delivery
+}
+
+// done is told msg is finished with after forwarding that many records of it
+func (p *producer) done(msg *amqp091.Delivery, records int) {
+	if p.config.QueueType == "stream" {
+		p.delivered(msg.Headers["x-stream-offset"])
+	}
+
+	if p.handoffs != nil {
+		p.handoffs <- handoff{msg, records, time.Now().Add(p.ackTimeout)}
+		return
+	}
+
+	if p.ackAfterSend {
+		p.forwarded = append(p.forwarded, msg)
+	}
+}
+
+func (p *producer) stopped() bool {
+	return p.config.StopAfter != 0 && p.iters >= p.config.StopAfter
+}
+
+func (p *producer) sampled() bool {
+	p.sampleCredit += p.config.SampleRate
+	if p.sampleCredit < 1 {
+		return false
+	}
+
+	p.sampleCredit--
+	return true
+}
+
+func (p *producer) ack() error {
+	if len(p.forwarded) == 0 {
+		return nil
+	}
+
+	defer func() { p.forwarded = p.forwarded[:0] }()
+	if !p.reordered {
+		return ackLatest(p.forwarded)
+	}
+
+	for _, msg := range p.forwarded {
+		if err := msg.Ack(false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fill reads up to chunk-size deliveries, cut short when ctx is done or messages closes,
+// in which case it also returns why
+func (p *producer) fill(ctx context.Context, messages <-chan amqp091.Delivery) ([]amqp091.Delivery, api.ExitReason) {
+	msgBuf := make([]amqp091.Delivery, 0, p.config.ChunkSize)
+	for uint(len(msgBuf)) < p.config.ChunkSize {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				if ctx.Err() != nil {
+					return msgBuf, api.ExitCanceled
+				}
+
+				return msgBuf, api.ExitBrokerClosed
+			}
+
+			msgBuf = append(msgBuf, msg)
+		case <-ctx.Done():
+			return msgBuf, api.ExitCanceled
+		}
+	}
+
+	return msgBuf, 0
+}
+
+// forward sends every record of msgBuf, acking as configured, until stop-after is reached or ctx
+// is done while send blocks, when the unforwarded rest of the chunk is requeued.
+// The error returned is the one the producer gives up on, others are sent on errs as they happen
+func (p *producer) forward(ctx context.Context, msgBuf []amqp091.Delivery) error {
+	if len(msgBuf) == 0 {
+		return nil
+	}
+
+	if !p.config.AutoAck && !p.ackAfterSend {
+		chunk := make([]*amqp091.Delivery, len(msgBuf))
+		for i := range msgBuf {
+			chunk[i] = &msgBuf[i]
+		}
+
+		if err := ackLatest(chunk); err != nil {
+			return err
+		}
+	}
+
+	if p.config.ChunkOrder == "lifo" {
+		slices.Reverse(msgBuf)
+	}
+
+	// seen holds the dedup-chunk-by-header values of the chunk so far
+	seen := make(map[string]struct{})
+	// complete is false when stop-after or cancellation cuts the chunk short
+	complete := true
+	// unforwarded is the rest of the chunk when cancellation cuts it short
+	var unforwarded []amqp091.Delivery
+	// offset is where the stream was forwarded up to before the chunk, a batch unsent isn't forwarded
+	offset := p.offset
+chunk:
+	for i := range msgBuf {
+		msg := &msgBuf[i]
+		if p.config.DedupChunkByHeader != "" {
+			if value, ok := msg.Headers[p.config.DedupChunkByHeader]; ok {
+				key := fmt.Sprint(value)
+				if _, dup := seen[key]; dup {
+					count(p.config.Queue, "deduplicated", 1)
+					p.done(msg, 0)
+					continue
+				}
+
+				seen[key] = struct{}{}
+			}
+		}
+
+		if p.selector != nil && !p.selector(msg) {
+			count(p.config.Queue, "unselected", 1)
+			p.done(msg, 0)
+			continue
+		}
+
+		if !p.sampled() {
+			if p.config.SampleRequeue {
+				if err := p.reject(msg, api.RejectSampledOut, true, nil); err != nil {
+					return err
+				}
+			} else {
+				p.done(msg, 0)
+			}
+			continue
+		}
+
+		end := p.config.span(p.tracer, "amqp consume", p.config.consumed(msg))
+
+		records, err := unpack(p.stages, msg.Body)
+		if err == nil && p.config.Bridge {
+			records, err = wrapAll(msg.ContentType, records)
+		}
+		if err == nil && p.batch != nil {
+			err = p.batch.check(records)
+		}
+		end(err)
+		if err != nil {
+			if !p.ackAfterSend {
+				// already acked along with its chunk
+				p.errs <- err
+				continue
+			}
+
+			if err := p.reject(msg, api.RejectUndecodable, false, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(records) == 0 {
+			p.done(msg, 0)
+		}
+
+		for j, record := range records {
+			p.throttle.wait()
+			if p.batch != nil {
+				p.batch.add(record)
+			} else if !p.sendRecord(ctx, record) {
+				complete, unforwarded = false, msgBuf[i:]
+				break chunk
+			}
+			p.iters++
+			if p.config.StateFile != "" && p.batch == nil {
+				if err := writeCount(p.config.StateFile, p.iters); err != nil {
+					return err
+				}
+			}
+			if j == len(records)-1 {
+				p.done(msg, len(records))
+			}
+			if p.stopped() {
+				complete = i == len(msgBuf)-1 && j == len(records)-1
+				break chunk
+			}
+		}
+	}
+
+	if p.batch != nil && p.batch.records != 0 {
+		records := p.batch.records
+		if !p.sendRecord(ctx, p.batch.take()) {
+			// none of the chunk was forwarded after all
+			p.iters, p.offset = p.iters-records, offset
+			complete, unforwarded = false, make([]amqp091.Delivery, len(p.forwarded))
+			for i, msg := range p.forwarded {
+				unforwarded[i] = *msg
+			}
+			p.forwarded = p.forwarded[:0]
+		} else if p.config.StateFile != "" {
+			if err := writeCount(p.config.StateFile, p.iters); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !p.ackAfterSend {
+		return nil
+	}
+
+	if p.config.Transactional {
+		// a rolled back chunk is requeued as the channel closes
+		if !complete {
+			return p.channel.TxRollback()
+		}
+
+		if err := p.ack(); err != nil {
+			return err
+		}
+
+		return p.channel.TxCommit()
+	}
+
+	if err := p.ack(); err != nil {
+		return err
+	}
+
+	return p.requeue(unforwarded)
+}
+
+// sendRecord sends record, unless ctx is done while send blocks
+func (p *producer) sendRecord(ctx context.Context, record []byte) bool {
+	select {
+	case p.send <- record:
+		return true
+	default:
+	}
+
+	select {
+	case p.send <- record:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// requeue nacks unforwarded deliveries back onto their queues, with a nack for each channel unless
+// deliveries forwarded before them may still be unacked: reordered, or waiting on ack-signal
+func (p *producer) requeue(unforwarded []amqp091.Delivery) error {
+	if len(unforwarded) == 0 {
+		return nil
+	}
+
+	count(p.config.Queue, "requeued-unforwarded", int64(len(unforwarded)))
+	if p.reordered || p.handoffs != nil {
+		for i := range unforwarded {
+			if err := unforwarded[i].Nack(false, true); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	latest := make(map[amqp091.Acknowledger]*amqp091.Delivery, 1)
+	for i := range unforwarded {
+		latest[unforwarded[i].Acknowledger] = &unforwarded[i]
+	}
+
+	for _, msg := range latest {
+		if err := msg.Nack(true, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// run forwards chunks until stop-after, cancellation, the broker closing or an error
+func (p *producer) run(ctx context.Context, messages <-chan amqp091.Delivery, closes <-chan *amqp091.Error, cancels <-chan string) *api.Exit {
+	for !p.stopped() {
+		msgBuf, reason := p.fill(ctx, messages)
+		// a drained producer goes on forwarding what was delivered once cancelled
+		forwarding := ctx
+		if p.drainTimeout != 0 {
+			forwarding = context.Background()
+		}
+
+		if err := p.forward(forwarding, msgBuf); err != nil {
+			if p.config.Transactional {
+				p.channel.TxRollback()
+			}
+			return &api.Exit{Reason: api.ExitError, Err: err}
+		}
+
+		if err := p.commitOffset(false); err != nil {
+			return &api.Exit{Reason: api.ExitError, Err: err}
+		}
+
+		switch reason {
+		case api.ExitCanceled:
+			if p.drainTimeout != 0 {
+				return p.drain(messages, closes, cancels)
+			}
+
+			return &api.Exit{Reason: api.ExitCanceled}
+		case api.ExitBrokerClosed:
+			return &api.Exit{Reason: api.ExitBrokerClosed, Err: closeErr(closes, cancels)}
+		}
+	}
+
+	return &api.Exit{Reason: api.ExitStopAfter}
+}
+
+// closeErr is the error the broker closed the channel with, or why it cancelled the consumer,
+// if either has been notified yet
+func closeErr(closes <-chan *amqp091.Error, cancels <-chan string) error {
+	select {
+	case err, ok := <-closes:
+		if ok && err != nil {
+			return err
+		}
+	default:
+	}
+
+	select {
+	case tag, ok := <-cancels:
+		if ok {
+			return fmt.Errorf("broker cancelled consumer %q", tag)
+		}
+	default:
+	}
+
+	return nil
+}
+
+// acksAfterForward reports whether the producer acks each chunk only as far as it was actually forwarded,
+// rather than all of it as soon as it's filled: whenever a chunk's messages may be forwarded as anything
+// other than one record each, or not at all, or the chunk may be cut short by cancellation,
+// from stop-signal or once drain-timeout expires, and the rest of it must be requeued
+func (config *queueConfig) acksAfterForward(unpacks, throttled bool) bool {
+	if config.AutoAck {
+		return false
+	}
+
+	return config.ackAfterSend || unpacks || throttled || config.SampleRequeue || config.AckSignal != "" || config.Transactional ||
+		config.ReorderHeader != "" || config.EmitChunkAsBatch || config.StopSignal != "" || config.DrainTimeout != ""
+}
+
+// validatePrefetch refuses an empty chunk, which would never forward anything, and a prefetch window
+// too small to hold a chunk, along with whatever reorder-header holds back: deliveries are only acked
+// once a chunk has filled, so it would deadlock
+func (config *queueConfig) validatePrefetch() error {
+	if config.ChunkSize == 0 {
+		return errors.New("chunk-size must be at least 1")
+	}
+
+	if config.AutoAck || config.GetMode || config.PrefetchCount == 0 {
+		return nil
+	}
+
+	window := int(config.ChunkSize)
+	if config.ReorderHeader != "" {
+		window += config.ReorderWindow
+	}
+
+	if config.PrefetchCount < window {
+		return fmt.Errorf("prefetch-count must be at least chunk-size plus any reorder-window, %d, got %d", window, config.PrefetchCount)
+	}
+
+	return nil
+}
+
+func produce(config *queueConfig) (sdk.Producer, error) {
+	config.role = "producer"
+	stages, err := config.pipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	throttle, err := newLimiter(config.MaxConsumeRate, config.ConsumeBurst)
+	if err != nil {
+		return nil, fmt.Errorf("max-consume-rate: %w", err)
+	}
+
+	stopSignal, err := config.stopSignal()
+	if err != nil {
+		return nil, err
+	}
+
+	pollInterval, pollIntervalMax, err := config.pollIntervals()
+	if err != nil {
+		return nil, err
+	}
+
+	consumeArgs, err := config.consumeArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.QueueType == "stream" && !config.GetMode && (config.PrefetchCount == 0 || config.AutoAck) {
+		return nil, errors.New("consuming a stream queue requires prefetch-count and manual acks")
+	}
+
+	if err := config.validatePrefetch(); err != nil {
+		return nil, err
+	}
+
+	ackTimeout, err := time.ParseDuration(config.AckTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("ack-timeout: %w", err)
+	}
+
+	if config.AckSignal != "" && config.AutoAck {
+		return nil, errors.New("ack-signal can't defer acks of auto-acked messages")
+	}
+
+	if config.Transactional && (config.AutoAck || config.AckSignal != "") {
+		return nil, errors.New("transactional acks each chunk itself, it can't be combined with auto-ack or ack-signal")
+	}
+
+	if config.SampleRequeue && config.AutoAck {
+		return nil, errors.New("sample-requeue can't requeue auto-acked messages")
+	}
+
+	reorderGapTimeout, err := config.reorderGapTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	drainTimeout, err := config.drainTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	commitInterval, err := config.offsetCommitInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.OffsetStore != "" {
+		if config.QueueType != "stream" || len(config.Sources) != 0 {
+			return nil, errors.New("offset-store requires queue-type stream, and can't be shared by sources")
+		}
+
+		next, ok, err := loadOffset(config.OffsetStore)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			consumeArgs["x-stream-offset"] = next
+		}
+	}
+
+	var tracer api.Tracer
+	if config.Tracer != "" {
+		if tracer, err = api.LookupTracer(config.Tracer); err != nil {
+			return nil, err
+		}
+	}
+
+	reconnectInterval, err := config.reconnectInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	var combined *batch
+	if config.EmitChunkAsBatch {
+		if config.AckSignal != "" {
+			return nil, errors.New("emit-chunk-as-batch can't be combined with ack-signal, which acks each record signalled")
+		}
+
+		if combined, err = newBatch(config.BatchFormat); err != nil {
+			return nil, err
+		}
+	}
+
+	var match selector
+	if config.Selector != "" {
+		if match, err = parseSelector(config.Selector); err != nil {
+			return nil, fmt.Errorf("selector: %w", err)
+		}
+	}
+
+	if len(config.Sources) != 0 && (config.Transactional || config.RejectSink != "") {
+		return nil, errors.New("sources can't be combined with transactional or reject-sink, which need a single channel")
+	}
+
+	p := &producer{
+		config:       config,
+		stages:       stages,
+		throttle:     throttle,
+		selector:     match,
+		tracer:       tracer,
+		batch:        combined,
+		ackAfterSend: config.acksAfterForward(len(stages) != 0, throttle != nil),
+		reordered:    config.ReorderHeader != "" || config.ChunkOrder == "lifo",
+		ackTimeout:   ackTimeout,
+		drainTimeout: drainTimeout,
+
+		commitInterval: commitInterval,
+		committedAt:    time.Now(),
+
+		consumeArgs:       consumeArgs,
+		pollInterval:      pollInterval,
+		pollIntervalMax:   pollIntervalMax,
+		reorderGapTimeout: reorderGapTimeout,
+		reconnectInterval: reconnectInterval,
+	}
+
+	subs, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO if we encounter an err before we return data, errs, the function will deadlock if errs is unbuffered
+	return func(send chan<- []byte, out chan<- error) {
+		errs, flush := bufferErrors(out, config.ErrorBufferSize, config.ErrorOverflow)
+		p.send, p.errs = send, errs
+
+		// the exit goes straight to out, it mustn't be dropped or coalesced
+		exit := &api.Exit{Reason: api.ExitError}
+		defer close(send)
+		defer close(out)
+		defer func() { out <- exit }()
+		defer flush()
+		// acks waiting on ack-signal still go out before the last subscriptions close
+		defer func() { p.close(subs) }()
+
+		if config.AckSignal != "" {
+			p.handoffs = make(chan handoff, max(config.PrefetchCount, int(config.ChunkSize)))
+			settled := make(chan struct{})
+			go func() {
+				defer close(settled)
+				p.settle(api.Completed(config.AckSignal))
+			}()
+			defer func() {
+				close(p.handoffs)
+				<-settled
+			}()
+		}
+
+		// stop-signal cancels ctx like any other cancellation of the consume loop:
+		// the chunk being filled is cut short, forwarded and acked as far as send takes it
+		// without blocking, and the rest is requeued, then the producer exits
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if stopSignal != nil {
+			ctx, cancel = signal.NotifyContext(ctx, stopSignal)
+			defer cancel()
+		}
+
+		// the count is persisted after each message is forwarded, a crash between the two
+		// forwards that message again on restart: at-least-once toward stop-after
+		if config.StateFile != "" {
+			if p.iters, err = readCount(config.StateFile); err != nil {
+				exit.Err = err
+				return
+			}
+		}
+
+		if err := p.skipBacklog(subs); err != nil {
+			exit.Err = err
+			return
+		}
+
+		for {
+			exit = p.session(ctx, subs)
+			if err := p.commitOffset(true); err != nil {
+				errs <- err
+			}
+
+			if !p.closedByBroker(exit) {
+				return
+			}
+
+			switch config.OnConnectionClose {
+			case closeStop:
+				exit = &api.Exit{Reason: api.ExitCanceled, Err: exit.Err}
+				return
+			case closeError:
+				return
+			}
+
+			log.Printf("amqp: %s closed, reconnecting: %v", config.Queue, exit.Err)
+			p.close(subs)
+			if subs = p.reconnect(ctx); subs == nil {
+				exit = &api.Exit{Reason: api.ExitCanceled}
+				return
+			}
+		}
+	}, nil
+}
+
+// open subscribes to each queue, readying the channels to produce from
+func (p *producer) open() ([]*subscription, error) {
+	subs, err := subscribe(p.config)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first subscription is the only one without sources
+	p.channel = subs[0].channel
+	if p.config.RejectSink != "" {
+		if _, err := p.channel.QueueDeclare(p.config.RejectSink, p.config.Durable, false, false, false, nil); err != nil {
+			subs[0].conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.config.Transactional {
+		if err := p.channel.Tx(); err != nil {
+			subs[0].conn.Close()
+			return nil, err
+		}
+	}
+
+	return subs, nil
+}
+
+// session produces from subs until the producer exits or they close, leaving them to be closed
+func (p *producer) session(ctx context.Context, subs []*subscription) *api.Exit {
+	config := p.config
+	// acks of what was forwarded from earlier channels can't be sent anymore
+	p.forwarded = p.forwarded[:0]
+
+	p.channels = make([]*amqp091.Channel, len(subs))
+	for i, sub := range subs {
+		p.channels[i] = sub.channel
+	}
+
+	closings, cancellings := make([]<-chan *amqp091.Error, len(subs)), make([]<-chan string, len(subs))
+	for i, sub := range subs {
+		closings[i] = sub.channel.NotifyClose(make(chan *amqp091.Error, config.NotifyCloseBuffer))
+		cancellings[i] = sub.channel.NotifyCancel(make(chan string, config.NotifyCancelBuffer))
+	}
+	closes := fanIn(len(subs)*config.NotifyCloseBuffer, closings...)
+	cancels := fanIn(len(subs)*config.NotifyCancelBuffer, cancellings...)
+
+	// the stages between the consumer and the producer outlive ctx while draining,
+	// to pass on what was already delivered
+	streamCtx, stopStream := ctx, context.CancelFunc(func() {})
+	if p.drainTimeout != 0 {
+		streamCtx, stopStream = context.WithCancel(context.Background())
+		defer stopStream()
+	}
+	p.stopStream = stopStream
+
+	p.consumerTag = ""
+	if p.drainTimeout != 0 || config.ConnectionName != "" {
+		p.consumerTag = config.consumerTag(subs[0].queue.Name)
+	}
+
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	defer stopPolling()
+
+	deliveries := make([]<-chan amqp091.Delivery, len(subs))
+	for i, sub := range subs {
+		if config.GetMode {
+			deliveries[i] = poll(pollCtx, sub.channel, sub.queue.Name, config.AutoAck, p.pollInterval, p.pollIntervalMax, p.errs)
+			continue
+		}
+
+		var err error
+		if deliveries[i], err = sub.channel.Consume(sub.queue.Name, p.consumerTag, config.AutoAck, false, false, config.NoWait, p.resumeArgs()); err != nil {
+			stopPolling()
+			p.merged = fanIn(0, deliveries[:i]...)
+			return &api.Exit{Reason: api.ExitError, Err: err}
+		}
+	}
+
+	// amqp091 already queues whatever prefetch-count lets the broker deliver, delivery-buffer
+	// hands it on ahead of the producer asking, so bursts aren't held up by each hand off
+	p.merged = buffered(config.DeliveryBuffer, fanIn(0, deliveries...))
+	if config.GetMode {
+		// the pollers must be done with errs and their channels before either is closed
+		defer func() {
+			stopPolling()
+			for range p.merged {
+			}
+		}()
+	}
+
+	messages := p.merged
+	if config.ReorderHeader != "" {
+		messages = reorder(streamCtx, messages, config.ReorderHeader, config.ReorderWindow, p.reorderGapTimeout)
+	}
+
+	return p.run(ctx, messages, closes, cancels)
+}
+
+// close disconnects subs, then takes whatever their sources were still feeding
+func (p *producer) close(subs []*subscription) {
+	for _, sub := range subs {
+		disconnect(sub.conn, sub.channel, p.errs)
+	}
+
+	if p.merged != nil {
+		for range p.merged {
+		}
+		p.merged = nil
+	}
+}
+
+// closedByBroker reports whether exit is down to the broker closing the connection or channel
+func (p *producer) closedByBroker(exit *api.Exit) bool {
+	return exit.Reason == api.ExitBrokerClosed || exit.Reason == api.ExitError && errors.Is(exit.Err, amqp091.ErrClosed)
+}
+
+// reconnect opens new subscriptions each reconnect-interval until one succeeds,
+// or returns nil once ctx is done
+func (p *producer) reconnect(ctx context.Context) []*subscription {
+	for {
+		select {
+		case <-time.After(p.reconnectInterval):
+		case <-ctx.Done():
+			return nil
+		}
+
+		subs, err := p.open()
+		if err == nil {
+			count(p.config.Queue, "reconnects", 1)
+			return subs
+		}
+
+		p.errs <- fmt.Errorf("reconnect: %w", err)
+	}
+}
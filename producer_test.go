@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// fakeChannel records how each of its delivery tags was settled
+type fakeChannel struct {
+	settled map[uint64]string
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{settled: make(map[uint64]string)}
+}
+
+func (c *fakeChannel) settle(tag uint64, multiple bool, how string) error {
+	if !multiple {
+		c.settled[tag] = how
+		return nil
+	}
+
+	for pending := uint64(1); pending <= tag; pending++ {
+		if _, ok := c.settled[pending]; !ok {
+			c.settled[pending] = how
+		}
+	}
+
+	return nil
+}
+
+func (c *fakeChannel) Ack(tag uint64, multiple bool) error {
+	return c.settle(tag, multiple, "acked")
+}
+
+func (c *fakeChannel) Nack(tag uint64, multiple, requeue bool) error {
+	if requeue {
+		return c.settle(tag, multiple, "requeued")
+	}
+
+	return c.settle(tag, multiple, "nacked")
+}
+
+func (c *fakeChannel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+// check fails t unless the channel's tags were settled as want
+func (c *fakeChannel) check(t *testing.T, want map[uint64]string) {
+	t.Helper()
+	if fmt.Sprint(c.settled) != fmt.Sprint(want) {
+		t.Errorf("settled %v, want %v", c.settled, want)
+	}
+}
+
+// testDeliveries are n deliveries on channel, tagged from 1 with bodies "1", "2"...
+func testDeliveries(channel *fakeChannel, n int) []amqp091.Delivery {
+	msgs := make([]amqp091.Delivery, n)
+	for i := range msgs {
+		msgs[i] = amqp091.Delivery{Acknowledger: channel, DeliveryTag: uint64(i + 1), Body: []byte(fmt.Sprint(i + 1))}
+	}
+
+	return msgs
+}
+
+// testProducer forwards to send, sampling every message as the sample-rate default does
+func testProducer(config *queueConfig, send chan []byte) *producer {
+	config.SampleRate = 1
+	return &producer{
+		config:       config,
+		ackAfterSend: config.acksAfterForward(false, false),
+		send:         send,
+		errs:         make(chan error, 16),
+	}
+}
+
+func TestAcksAfterForward(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config queueConfig
+		want   bool
+	}{
+		{"default", queueConfig{}, false},
+		{"auto-ack", queueConfig{AutoAck: true, StopSignal: "SIGTERM"}, false},
+		{"stop-signal", queueConfig{StopSignal: "SIGTERM"}, true},
+		{"drain-timeout", queueConfig{DrainTimeout: "1s"}, true},
+		{"transactional", queueConfig{Transactional: true}, true},
+		{"at-least-once", queueConfig{ackAfterSend: true}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.config.acksAfterForward(false, false); got != test.want {
+				t.Errorf("acksAfterForward() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestForwardCancelledMidChunk(t *testing.T) {
+	channel := newFakeChannel()
+	// send takes one record without blocking, then blocks with ctx already done
+	send := make(chan []byte, 1)
+	p := testProducer(&queueConfig{Queue: "q", ChunkSize: 3, StopSignal: "SIGTERM"}, send)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.forward(ctx, testDeliveries(channel, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(<-send); got != "1" {
+		t.Errorf("forwarded %q, want 1", got)
+	}
+
+	channel.check(t, map[uint64]string{1: "acked", 2: "requeued", 3: "requeued"})
+}
+
+func TestForwardUncancelled(t *testing.T) {
+	channel := newFakeChannel()
+	send := make(chan []byte, 3)
+	p := testProducer(&queueConfig{Queue: "q", ChunkSize: 3}, send)
+	if err := p.forward(context.Background(), testDeliveries(channel, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(send) != 3 {
+		t.Errorf("forwarded %d records, want 3", len(send))
+	}
+
+	channel.check(t, map[uint64]string{1: "acked", 2: "acked", 3: "acked"})
+}
+
+func TestValidatePrefetch(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config queueConfig
+		ok     bool
+	}{
+		{"unlimited", queueConfig{ChunkSize: 10}, true},
+		{"no chunk", queueConfig{}, false},
+		{"no chunk auto-ack", queueConfig{AutoAck: true}, false},
+		{"chunk", queueConfig{ChunkSize: 10, PrefetchCount: 10}, true},
+		{"below chunk", queueConfig{ChunkSize: 10, PrefetchCount: 9}, false},
+		{"chunk and window", queueConfig{ChunkSize: 10, PrefetchCount: 15, ReorderHeader: "seq", ReorderWindow: 5}, true},
+		{"below chunk and window", queueConfig{ChunkSize: 10, PrefetchCount: 14, ReorderHeader: "seq", ReorderWindow: 5}, false},
+		{"window without reorder-header", queueConfig{ChunkSize: 10, PrefetchCount: 10, ReorderWindow: 5}, true},
+		{"auto-ack", queueConfig{ChunkSize: 10, PrefetchCount: 1, AutoAck: true}, true},
+		{"get-mode", queueConfig{ChunkSize: 10, PrefetchCount: 1, GetMode: true}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.validatePrefetch(); (err == nil) != test.ok {
+				t.Errorf("validatePrefetch() = %v, want ok %t", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestForwardBatchUnsent(t *testing.T) {
+	channel := newFakeChannel()
+	p := testProducer(&queueConfig{Queue: "q", ChunkSize: 3, QueueType: "stream", EmitChunkAsBatch: true}, make(chan []byte))
+	combined, err := newBatch(batchNewline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.batch, p.offset = combined, 10
+	msgs := testDeliveries(channel, 3)
+	for i := range msgs {
+		msgs[i].Headers = amqp091.Table{"x-stream-offset": int64(10 + i)}
+	}
+
+	// nothing takes the batch before ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.forward(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.offset != 10 || p.iters != 0 {
+		t.Errorf("forwarded to offset %d after %d records, want 10 after none", p.offset, p.iters)
+	}
+
+	channel.check(t, map[uint64]string{1: "requeued", 2: "requeued", 3: "requeued"})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// limiter is a token bucket refilled at rate tokens per second, holding at most burst tokens
+type limiter struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+// newLimiter builds a limiter starting full, a rate of 0 is unlimited and yields nil
+func newLimiter(rate float64, burst int) (*limiter, error) {
+	if rate < 0 {
+		return nil, fmt.Errorf("rate must not be negative, got %g", rate)
+	}
+
+	if rate == 0 {
+		return nil, nil
+	}
+
+	if burst < 1 {
+		return nil, fmt.Errorf("burst must be at least 1, got %d", burst)
+	}
+
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}, nil
+}
+
+// wait blocks until a token is available and takes it, a nil limiter never blocks
+func (l *limiter) wait() {
+	if l == nil {
+		return
+	}
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		time.Sleep(time.Duration((1 - l.tokens) / l.rate * float64(time.Second)))
+		l.tokens, l.last = 1, time.Now()
+	}
+
+	l.tokens--
+}
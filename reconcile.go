@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// declarer runs declares on channel, replacing it when the broker closes it over a failed declare
+type declarer struct {
+	conn    *amqp091.Connection
+	channel *amqp091.Channel
+	// reconcile and destructive are reconcile-topology and allow-destructive
+	reconcile, destructive bool
+	// broker and owner key and describe its declarations, held lists those it holds
+	broker, owner string
+	held          []string
+}
+
+// declare runs declare, and if the broker refuses it as conflicting with what already exists
+// (PRECONDITION_FAILED) with reconcile-topology set, deletes what exists with remove then declares again.
+// Deleting is only done with allow-destructive, every correction made is logged
+func (d *declarer) declare(what string, declare, remove func(*amqp091.Channel) error) error {
+	err := declare(d.channel)
+	amqpErr := new(amqp091.Error)
+	if !d.reconcile || !errors.As(err, &amqpErr) || amqpErr.Code != amqp091.PreconditionFailed {
+		return err
+	}
+
+	if !d.destructive {
+		return fmt.Errorf("%s exists differently to the config, reconcile-topology needs allow-destructive to delete and redeclare it: %w", what, err)
+	}
+
+	// the failed declare closed the channel
+	if d.channel, err = d.conn.Channel(); err != nil {
+		return err
+	}
+
+	if err := remove(d.channel); err != nil {
+		return fmt.Errorf("reconcile %s: %w", what, err)
+	}
+
+	log.Printf("amqp: reconcile-topology deleted %s to redeclare it as configured", what)
+	if err := declare(d.channel); err != nil {
+		return fmt.Errorf("reconcile %s: %w", what, err)
+	}
+
+	log.Printf("amqp: reconcile-topology redeclared %s", what)
+	return nil
+}
+
+func (d *declarer) declareQueue(name string, durable, autoDelete, exclusive bool, args amqp091.Table) (amqp091.Queue, error) {
+	if err := d.hold("queue", name, &declaration{durable: durable, autoDelete: autoDelete, flag: exclusive, args: args, owner: d.owner}); err != nil {
+		return amqp091.Queue{}, err
+	}
+
+	var queue amqp091.Queue
+	err := d.declare(fmt.Sprintf("queue %q", name), func(channel *amqp091.Channel) (err error) {
+		queue, err = channel.QueueDeclare(name, durable, autoDelete, exclusive, false, args)
+		return err
+	}, func(channel *amqp091.Channel) error {
+		purged, err := channel.QueueDelete(name, false, false, false)
+		if err == nil && purged != 0 {
+			log.Printf("amqp: reconcile-topology dropped %d messages deleting queue %q", purged, name)
+		}
+
+		return err
+	})
+
+	return queue, err
+}
+
+func (d *declarer) declareExchange(name, kind string, durable, autoDelete, internal bool, args amqp091.Table) error {
+	if err := d.hold("exchange", name, &declaration{kind: kind, durable: durable, autoDelete: autoDelete, flag: internal, args: args, owner: d.owner}); err != nil {
+		return err
+	}
+
+	return d.declare(fmt.Sprintf("exchange %q", name), func(channel *amqp091.Channel) error {
+		return channel.ExchangeDeclare(name, kind, durable, autoDelete, internal, false, args)
+	}, func(channel *amqp091.Channel) error {
+		return channel.ExchangeDelete(name, false, false)
+	})
+}
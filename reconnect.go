@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	closeStop      = "stop"
+	closeReconnect = "reconnect"
+	closeError     = "error"
+)
+
+func (config *queueConfig) reconnectInterval() (time.Duration, error) {
+	interval, err := time.ParseDuration(config.ReconnectInterval)
+	if err != nil {
+		return 0, fmt.Errorf("reconnect-interval: %w", err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("reconnect-interval must be positive, got %s", interval)
+	}
+
+	return interval, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// reject nacks msg for reason, counting it as rejected.{reason}, and reporting it on errs when there's
+// a cause. With a reject-sink a message not being requeued is instead republished to the sink queue
+// with its reason in the x-reject-reason header (and cause in x-reject-error), then acked
+func (p *producer) reject(msg *amqp091.Delivery, reason api.RejectReason, requeue bool, cause error) error {
+	count(p.config.Queue, "rejected."+string(reason), 1)
+	if cause != nil {
+		p.errs <- &api.Rejection{Reason: reason, DeliveryTag: msg.DeliveryTag, MessageID: msg.MessageId, Requeued: requeue, Err: cause}
+	}
+
+	if requeue || p.config.RejectSink == "" {
+		return msg.Nack(false, requeue)
+	}
+
+	headers := amqp091.Table{}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	headers["x-reject-reason"] = string(reason)
+	if cause != nil {
+		headers["x-reject-error"] = cause.Error()
+	}
+
+	if err := p.channel.PublishWithContext(context.Background(), "", p.config.RejectSink, false, false, amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}); err != nil {
+		p.errs <- fmt.Errorf("reject-sink %q: %w", p.config.RejectSink, err)
+		return msg.Nack(false, false)
+	}
+
+	return msg.Ack(false)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func (config *queueConfig) reorderGapTimeout() (time.Duration, error) {
+	gapTimeout, err := time.ParseDuration(config.ReorderGapTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("reorder-gap-timeout: %w", err)
+	}
+
+	if gapTimeout <= 0 {
+		return 0, fmt.Errorf("reorder-gap-timeout must be positive, got %s", gapTimeout)
+	}
+
+	return gapTimeout, nil
+}
+
+// headerInt reads an integer header value, whichever integer type (or numeric string) it was sent as
+func headerInt(value any) (int64, bool) {
+	switch value := value.(type) {
+	case int8:
+		return int64(value), true
+	case int16:
+		return int64(value), true
+	case int32:
+		return int64(value), true
+	case int64:
+		return value, true
+	case int:
+		return int64(value), true
+	case uint8:
+		return int64(value), true
+	case uint16:
+		return int64(value), true
+	case uint32:
+		return int64(value), true
+	case string:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return n, err == nil
+	}
+
+	return 0, false
+}
+
+type sequenced struct {
+	seq int64
+	msg amqp091.Delivery
+}
+
+// reorder holds up to window deliveries from in to release them in order of their header sequence numbers.
+// A gap in the sequence is skipped once the window is full, or once the earliest held delivery has
+// waited gapTimeout for it. Deliveries without a sequence number, or one already passed, go straight through.
+// Held deliveries cost memory and add up to gapTimeout of latency, the first sequence number is learnt
+// from the first release, so the stream should start within a window of the lowest
+func reorder(ctx context.Context, in <-chan amqp091.Delivery, header string, window int, gapTimeout time.Duration) <-chan amqp091.Delivery {
+	out := make(chan amqp091.Delivery)
+	go func() {
+		defer close(out)
+		held := make([]sequenced, 0, window)
+		var next int64
+		started := false
+		var gap *time.Timer
+		var gapped <-chan time.Time
+
+		emit := func(msg amqp091.Delivery) bool {
+			select {
+			case out <- msg:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// release emits the held head, and everything in sequence after it
+		release := func() bool {
+			for len(held) != 0 && (!started || held[0].seq <= next) {
+				head := held[0]
+				held = held[1:]
+				if !emit(head.msg) {
+					return false
+				}
+
+				next, started = head.seq+1, true
+			}
+
+			if gap != nil {
+				gap.Stop()
+				gap, gapped = nil, nil
+			}
+
+			if len(held) != 0 {
+				gap = time.NewTimer(gapTimeout)
+				gapped = gap.C
+			}
+
+			return true
+		}
+
+		for {
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					for len(held) != 0 {
+						started = false
+						if !release() {
+							return
+						}
+					}
+					return
+				}
+
+				seq, ok := headerInt(msg.Headers[header])
+				if !ok || started && seq < next {
+					if !emit(msg) {
+						return
+					}
+					continue
+				}
+
+				at := sort.Search(len(held), func(i int) bool { return held[i].seq > seq })
+				held = append(held, sequenced{})
+				copy(held[at+1:], held[at:])
+				held[at] = sequenced{seq, msg}
+
+				if started && held[0].seq == next || len(held) > window {
+					if len(held) > window {
+						started = false
+					}
+
+					if !release() {
+						return
+					}
+				} else if gapped == nil {
+					gap = time.NewTimer(gapTimeout)
+					gapped = gap.C
+				}
+			case <-gapped:
+				gap, gapped = nil, nil
+				started = false
+				if !release() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
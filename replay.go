@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/psyduck-etl/sdk"
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// replayConfig configures the amqp-dlx-replay resource
+type replayConfig struct {
+	Connection  string  `cty:"connection"`
+	Queue       string  `cty:"queue"`
+	ReplayRate  float64 `cty:"replay-rate"`
+	ReplayBurst int     `cty:"replay-burst"`
+	ReplayCount int     `cty:"replay-count"`
+}
+
+func parseReplayConfig(parse sdk.Parser) (*replayConfig, error) {
+	config := new(replayConfig)
+	if err := parse(config); err != nil {
+		return nil, err
+	}
+
+	if config.ReplayCount < 0 {
+		return nil, fmt.Errorf("replay-count must not be negative, got %d", config.ReplayCount)
+	}
+
+	return config, nil
+}
+
+// deathOrigin is where a dead lettered message was last published before dying, read from the x-death header.
+// The broker keeps one x-death entry per queue and reason a message died for, the most recent first, each
+// naming the exchange it had been published to and the routing-keys it had been published with,
+// so replaying from the first entry republishes it as it was before its latest death
+func deathOrigin(headers amqp091.Table) (string, string, error) {
+	deaths, ok := headers["x-death"].([]any)
+	if !ok || len(deaths) == 0 {
+		return "", "", errors.New("no x-death header, the message wasn't dead lettered")
+	}
+
+	death, ok := deaths[0].(amqp091.Table)
+	if !ok {
+		return "", "", fmt.Errorf("x-death entry is a %T, not a table", deaths[0])
+	}
+
+	exchange, ok := death["exchange"].(string)
+	if !ok {
+		return "", "", errors.New("x-death entry has no exchange")
+	}
+
+	keys, ok := death["routing-keys"].([]any)
+	if !ok || len(keys) == 0 {
+		return "", "", errors.New("x-death entry has no routing-keys")
+	}
+
+	key, ok := keys[0].(string)
+	if !ok {
+		return "", "", fmt.Errorf("x-death routing key is a %T, not a string", keys[0])
+	}
+
+	return exchange, key, nil
+}
+
+// replay republishes msg with its properties to where it was dead lettered from, waiting on the broker's confirm
+func replay(channel *amqp091.Channel, msg amqp091.Delivery) error {
+	exchange, key, err := deathOrigin(msg.Headers)
+	if err != nil {
+		return err
+	}
+
+	confirm, err := channel.PublishWithDeferredConfirmWithContext(context.Background(), exchange, key, false, false, amqp091.Publishing{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !confirm.Wait() {
+		return fmt.Errorf("broker nacked replay to %q with key %q", exchange, key)
+	}
+
+	return nil
+}
+
+// produceReplay replays the dead letter queue until it's empty or replay-count messages are replayed,
+// sending each replayed body on send. A message is only acked off the queue once its replay is confirmed,
+// one that can't be replayed is left on it and reported
+func produceReplay(config *replayConfig) (sdk.Producer, error) {
+	throttle, err := newLimiter(config.ReplayRate, config.ReplayBurst)
+	if err != nil {
+		return nil, fmt.Errorf("replay-rate: %w", err)
+	}
+
+	conn, err := amqp091.Dial(config.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func(send chan<- []byte, out chan<- error) {
+		exit := &api.Exit{Reason: api.ExitQueueEmpty}
+		defer close(send)
+		defer close(out)
+		defer func() { out <- exit }()
+		defer disconnect(conn, channel, out)
+
+		for replayed := 0; config.ReplayCount == 0 || replayed < config.ReplayCount; {
+			msg, ok, err := channel.Get(config.Queue, false)
+			if err != nil {
+				exit = &api.Exit{Reason: api.ExitError, Err: err}
+				return
+			}
+
+			if !ok {
+				return
+			}
+
+			throttle.wait()
+			if err := replay(channel, msg); err != nil {
+				out <- fmt.Errorf("replay message %d: %w", msg.DeliveryTag, err)
+				// left on the queue, but not redelivered to this channel so the rest can be replayed
+				continue
+			}
+
+			if err := msg.Ack(false); err != nil {
+				exit = &api.Exit{Reason: api.ExitError, Err: err}
+				return
+			}
+
+			count(config.Queue, "replayed", 1)
+			send <- msg.Body
+			replayed++
+		}
+
+		exit = &api.Exit{Reason: api.ExitStopAfter}
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// queueRouter publishes messages to the queue named at queue-name-json-path in their body,
+// declaring each queue the first time it's routed to on a channel of its own,
+// so a refused declare can't close the publishing channel
+type queueRouter struct {
+	config   *queueConfig
+	conn     *amqp091.Connection
+	channel  *amqp091.Channel
+	declared map[string]struct{}
+}
+
+func newQueueRouter(config *queueConfig, conn *amqp091.Connection) *queueRouter {
+	return &queueRouter{config: config, conn: conn, declared: make(map[string]struct{})}
+}
+
+// route is the queue body names, with queue-prefix applied, false when it names none
+func (router *queueRouter) route(body []byte) (string, bool) {
+	value, ok := lookupJSON(body, router.config.QueueNameJSONPath)
+	if !ok {
+		return "", false
+	}
+
+	var name string
+	switch value := value.(type) {
+	case string:
+		name = value
+	case float64:
+		name = strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return "", false
+	}
+
+	if name == "" {
+		return "", false
+	}
+
+	return router.config.QueuePrefix + name, true
+}
+
+// declare declares queue like the configured queue, unless it already has been
+func (router *queueRouter) declare(queue string) error {
+	if _, ok := router.declared[queue]; ok {
+		return nil
+	}
+
+	if router.channel == nil || router.channel.IsClosed() {
+		channel, err := router.conn.Channel()
+		if err != nil {
+			return err
+		}
+
+		router.channel = channel
+	}
+
+	if _, err := router.channel.QueueDeclare(queue, router.config.Durable, false, false, false, router.config.queueArgs()); err != nil {
+		return err
+	}
+
+	router.declared[queue] = struct{}{}
+	return nil
+}
+
+func (router *queueRouter) close() error {
+	if router.channel == nil {
+		return nil
+	}
+
+	return router.channel.Close()
+}
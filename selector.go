@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// selector reports whether a delivery matches the selector expression
+type selector func(msg *amqp091.Delivery) bool
+
+// operand evaluates to a float64, string, bool, or nil when absent
+type operand func(msg *amqp091.Delivery) any
+
+// properties are the delivery properties a selector can name, any other identifier names a header
+var properties = map[string]operand{
+	"priority":         func(msg *amqp091.Delivery) any { return float64(msg.Priority) },
+	"delivery-mode":    func(msg *amqp091.Delivery) any { return float64(msg.DeliveryMode) },
+	"redelivered":      func(msg *amqp091.Delivery) any { return msg.Redelivered },
+	"type":             func(msg *amqp091.Delivery) any { return optional(msg.Type) },
+	"content-type":     func(msg *amqp091.Delivery) any { return optional(msg.ContentType) },
+	"content-encoding": func(msg *amqp091.Delivery) any { return optional(msg.ContentEncoding) },
+	"message-id":       func(msg *amqp091.Delivery) any { return optional(msg.MessageId) },
+	"correlation-id":   func(msg *amqp091.Delivery) any { return optional(msg.CorrelationId) },
+	"reply-to":         func(msg *amqp091.Delivery) any { return optional(msg.ReplyTo) },
+	"app-id":           func(msg *amqp091.Delivery) any { return optional(msg.AppId) },
+	"user-id":          func(msg *amqp091.Delivery) any { return optional(msg.UserId) },
+	"exchange":         func(msg *amqp091.Delivery) any { return msg.Exchange },
+	"routing-key":      func(msg *amqp091.Delivery) any { return msg.RoutingKey },
+}
+
+// optional is an unset string property as absent
+func optional(value string) any {
+	if value == "" {
+		return nil
+	}
+
+	return value
+}
+
+// headerValue is a header as a selector operand, numbers of any type compare as float64
+func headerValue(value any) any {
+	switch value := value.(type) {
+	case string, bool:
+		return value
+	case float32:
+		return float64(value)
+	case float64:
+		return value
+	}
+
+	if n, ok := headerInt(value); ok {
+		return float64(n)
+	}
+
+	return nil
+}
+
+// parseSelector compiles a selector expression. The grammar, keywords being case insensitive:
+//
+//	expr       = and { "OR" and }
+//	and        = not { "AND" not }
+//	not        = "NOT" not | "(" expr ")" | comparison
+//	comparison = operand ( "=" | "!=" | "<>" | "<" | "<=" | ">" | ">=" ) operand | operand "IS" [ "NOT" ] "NULL"
+//	operand    = identifier | 'string' | number | "TRUE" | "FALSE"
+//
+// An identifier is a delivery property (priority, delivery-mode, redelivered, type, content-type,
+// content-encoding, message-id, correlation-id, reply-to, app-id, user-id, exchange, routing-key)
+// or else a header name. Numbers compare numerically, strings lexically and booleans only for equality,
+// any comparison with an absent value or between mismatched types is false
+func parseSelector(expression string) (selector, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &selectorParser{tokens: tokens}
+	match, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.pos != len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q", parser.tokens[parser.pos].text)
+	}
+
+	return match, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func tokenize(expression string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'':
+			var text strings.Builder
+			for i++; ; i++ {
+				if i == len(runes) {
+					return nil, fmt.Errorf("unterminated string in selector %q", expression)
+				}
+
+				// a quote is escaped by doubling it
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+
+				text.WriteRune(runes[i])
+			}
+			tokens = append(tokens, token{tokenString, text.String()})
+			i++
+		case unicode.IsDigit(r) || r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			start := i
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i])})
+		case isWordRune(r):
+			start := i
+			for ; i < len(runes) && isWordRune(runes[i]); i++ {
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[start:i])})
+		case strings.ContainsRune("()", r):
+			tokens = append(tokens, token{tokenSymbol, string(r)})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			for i++; i < len(runes) && strings.ContainsRune("=<>", runes[i]); i++ {
+			}
+			tokens = append(tokens, token{tokenSymbol, string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected %q in selector %q", r, expression)
+		}
+	}
+
+	return tokens, nil
+}
+
+type selectorParser struct {
+	tokens []token
+	pos    int
+}
+
+// keyword consumes the next token if it's the keyword word
+func (parser *selectorParser) keyword(word string) bool {
+	if parser.pos < len(parser.tokens) && parser.tokens[parser.pos].kind == tokenWord && strings.EqualFold(parser.tokens[parser.pos].text, word) {
+		parser.pos++
+		return true
+	}
+
+	return false
+}
+
+// symbol consumes the next token if it's the symbol text
+func (parser *selectorParser) symbol(text string) bool {
+	if parser.pos < len(parser.tokens) && parser.tokens[parser.pos].kind == tokenSymbol && parser.tokens[parser.pos].text == text {
+		parser.pos++
+		return true
+	}
+
+	return false
+}
+
+func (parser *selectorParser) or() (selector, error) {
+	left, err := parser.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.keyword("OR") {
+		right, err := parser.and()
+		if err != nil {
+			return nil, err
+		}
+
+		left = func(left, right selector) selector {
+			return func(msg *amqp091.Delivery) bool { return left(msg) || right(msg) }
+		}(left, right)
+	}
+
+	return left, nil
+}
+
+func (parser *selectorParser) and() (selector, error) {
+	left, err := parser.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.keyword("AND") {
+		right, err := parser.not()
+		if err != nil {
+			return nil, err
+		}
+
+		left = func(left, right selector) selector {
+			return func(msg *amqp091.Delivery) bool { return left(msg) && right(msg) }
+		}(left, right)
+	}
+
+	return left, nil
+}
+
+func (parser *selectorParser) not() (selector, error) {
+	if parser.keyword("NOT") {
+		inner, err := parser.not()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(msg *amqp091.Delivery) bool { return !inner(msg) }, nil
+	}
+
+	if parser.symbol("(") {
+		inner, err := parser.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if !parser.symbol(")") {
+			return nil, parser.expected(")")
+		}
+
+		return inner, nil
+	}
+
+	return parser.comparison()
+}
+
+func (parser *selectorParser) comparison() (selector, error) {
+	left, err := parser.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.keyword("IS") {
+		negate := parser.keyword("NOT")
+		if !parser.keyword("NULL") {
+			return nil, parser.expected("NULL")
+		}
+
+		return func(msg *amqp091.Delivery) bool { return (left(msg) == nil) != negate }, nil
+	}
+
+	if parser.pos == len(parser.tokens) || parser.tokens[parser.pos].kind != tokenSymbol {
+		return nil, parser.expected("comparison")
+	}
+
+	op := parser.tokens[parser.pos].text
+	switch op {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("unknown comparison %q", op)
+	}
+	parser.pos++
+
+	right, err := parser.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg *amqp091.Delivery) bool { return compare(left(msg), op, right(msg)) }, nil
+}
+
+func (parser *selectorParser) operand() (operand, error) {
+	if parser.pos == len(parser.tokens) {
+		return nil, parser.expected("operand")
+	}
+
+	next := parser.tokens[parser.pos]
+	parser.pos++
+	switch next.kind {
+	case tokenString:
+		return func(*amqp091.Delivery) any { return next.text }, nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(next.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", next.text)
+		}
+
+		return func(*amqp091.Delivery) any { return number }, nil
+	case tokenWord:
+		switch {
+		case strings.EqualFold(next.text, "TRUE"):
+			return func(*amqp091.Delivery) any { return true }, nil
+		case strings.EqualFold(next.text, "FALSE"):
+			return func(*amqp091.Delivery) any { return false }, nil
+		}
+
+		if property, ok := properties[next.text]; ok {
+			return property, nil
+		}
+
+		return func(msg *amqp091.Delivery) any { return headerValue(msg.Headers[next.text]) }, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q, want an operand", next.text)
+}
+
+func (parser *selectorParser) expected(what string) error {
+	if parser.pos == len(parser.tokens) {
+		return fmt.Errorf("selector ended, want %s", what)
+	}
+
+	return fmt.Errorf("unexpected %q, want %s", parser.tokens[parser.pos].text, what)
+}
+
+func compare(left any, op string, right any) bool {
+	switch left := left.(type) {
+	case float64:
+		if right, ok := right.(float64); ok {
+			return ordered(left, op, right)
+		}
+	case string:
+		if right, ok := right.(string); ok {
+			return ordered(left, op, right)
+		}
+	case bool:
+		if right, ok := right.(bool); ok {
+			switch op {
+			case "=":
+				return left == right
+			case "!=", "<>":
+				return left != right
+			}
+		}
+	}
+
+	return false
+}
+
+func ordered[T float64 | string](left T, op string, right T) bool {
+	switch op {
+	case "=":
+		return left == right
+	case "!=", "<>":
+		return left != right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	}
+
+	return false
+}
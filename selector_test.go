@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestSelector(t *testing.T) {
+	msg := &amqp091.Delivery{
+		Priority:    5,
+		ContentType: "application/json",
+		Exchange:    "events",
+		RoutingKey:  "orders.created",
+		Headers: amqp091.Table{
+			"name":  "O'Brien",
+			"quote": "'",
+			"temp":  int32(-3),
+			"count": 3,
+			"i8":    int8(7),
+			"i16":   int16(7),
+			"i32":   int32(7),
+			"i64":   int64(7),
+			"u8":    uint8(7),
+			"u16":   uint16(7),
+			"u32":   uint32(7),
+			"f32":   float32(7),
+			"f64":   float64(7),
+			"s":     "7",
+		},
+	}
+
+	for _, test := range []struct {
+		expression string
+		want       bool
+	}{
+		// AND binds tighter than OR, NOT tighter than AND
+		{"priority = 5 OR priority = 1 AND redelivered = TRUE", true},
+		{"priority = 1 AND redelivered = TRUE OR priority = 5", true},
+		{"(priority = 5 OR priority = 1) AND redelivered = TRUE", false},
+		{"NOT priority = 5 AND priority = 1", false},
+		{"NOT (priority = 5 AND priority = 1)", true},
+		{"NOT NOT priority = 5", true},
+		{"priority = 5 and not redelivered = true", true},
+
+		{"missing IS NULL", true},
+		{"missing IS NOT NULL", false},
+		{"name IS NULL", false},
+		{"name is not null", true},
+		{"type IS NULL", true},
+		{"content-type IS NOT NULL", true},
+
+		{"name = 'O''Brien'", true},
+		{"name = 'OBrien'", false},
+		{"quote = ''''", true},
+		{"routing-key >= 'orders.' AND routing-key < 'orders/'", true},
+		{"exchange <> 'events'", false},
+
+		{"temp = -3", true},
+		{"temp < -2", true},
+		{"temp>-4", true},
+		{"temp > -2.5", false},
+
+		// mismatched types and absent values never compare, not even as unequal
+		{"count = '3'", false},
+		{"count != '3'", false},
+		{"name = 3", false},
+		{"redelivered = 'false'", false},
+		{"redelivered < TRUE", false},
+		{"missing = 1", false},
+		{"missing != 1", false},
+		{"s = 7", false},
+		{"s = '7'", true},
+
+		{"i8 = 7", true},
+		{"i16 = 7", true},
+		{"i32 = 7", true},
+		{"i64 = 7", true},
+		{"u8 = 7", true},
+		{"u16 = 7", true},
+		{"u32 = 7", true},
+		{"f32 = 7", true},
+		{"f64 = 7.0", true},
+		{"i8 = i64 AND u32 = f64", true},
+		{"i64 > 6.5", true},
+	} {
+		t.Run(test.expression, func(t *testing.T) {
+			match, err := parseSelector(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := match(msg); got != test.want {
+				t.Errorf("matched %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"priority =",
+		"priority == 5",
+		"priority ~ 5",
+		"(priority = 5",
+		"priority = 5)",
+		"name = 'open",
+		"name IS 5",
+		"priority = 5 AND",
+		"priority = 1.2.3",
+	} {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := parseSelector(expression); err == nil {
+				t.Error("parsed")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func (config *queueConfig) validateTags() error {
+	for _, tag := range config.ConnectionTags {
+		if key, _, ok := strings.Cut(tag, "="); !ok || key == "" {
+			return fmt.Errorf("connection-tags must be key=value, got %q", tag)
+		}
+	}
+
+	return nil
+}
+
+// clientProperties are the client properties the connection advertises: the library's product and version,
+// connection_name, an instance of the host and pid, and every connection-tags key. The management UI
+// names and logs connections by connection_name, and lists the rest among each connection's client properties
+func (config *queueConfig) clientProperties() amqp091.Table {
+	properties := amqp091.NewConnectionProperties()
+	if config.ConnectionName != "" {
+		properties.SetClientConnectionName(config.ConnectionName)
+	}
+
+	hostname, _ := os.Hostname()
+	properties["instance"] = fmt.Sprintf("%s/%d", hostname, os.Getpid())
+	for _, tag := range config.ConnectionTags {
+		key, value, _ := strings.Cut(tag, "=")
+		properties[key] = value
+	}
+
+	return properties
+}
+
+// consumerTagPrefix leads the tags of the producer's consumers, listed on their channels
+// in the management UI, with connection-name
+func (config *queueConfig) consumerTagPrefix() string {
+	if config.ConnectionName != "" {
+		return config.ConnectionName
+	}
+
+	return "psyduck"
+}
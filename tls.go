@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// parsePins decodes tls-pinned-cert-sha256 fingerprints, hex with optional colon separators
+func (config *queueConfig) parsePins() ([][]byte, error) {
+	pins := make([][]byte, len(config.TLSPinnedCertSHA256))
+	for i, fingerprint := range config.TLSPinnedCertSHA256 {
+		pin, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("tls-pinned-cert-sha256 %q isn't a hex SHA-256 fingerprint", fingerprint)
+		}
+
+		pins[i] = pin
+	}
+
+	return pins, nil
+}
+
+// tlsConfig builds the TLS config for amqps connections the way amqp091 would from the URI
+// (cacertfile, certfile, keyfile, server_name_indication), additionally requiring the broker's
+// certificate to match one of tls-pinned-cert-sha256. Without pins it's nil, leaving it all to amqp091
+func (config *queueConfig) tlsConfig(url string) (*tls.Config, error) {
+	pins, err := config.parsePins()
+	if err != nil || len(pins) == 0 {
+		return nil, err
+	}
+
+	uri, err := amqp091.ParseURI(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if uri.Scheme != "amqps" {
+		return nil, errors.New("tls-pinned-cert-sha256 requires an amqps connection")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: uri.ServerName,
+		// runs after the usual chain verification, so a pin narrows what the CAs accept
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("broker presented no certificate")
+			}
+
+			sum := sha256.Sum256(rawCerts[0])
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin) {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("broker certificate sha256 %s matches no pin", hex.EncodeToString(sum[:]))
+		},
+	}
+
+	if uri.CACertFile != "" {
+		data, err := os.ReadFile(uri.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificate: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(data)
+	}
+
+	if uri.CertFile != "" && uri.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(uri.CertFile, uri.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
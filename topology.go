@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type exchangeDecl struct {
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	Durable    bool          `json:"durable"`
+	AutoDelete bool          `json:"auto-delete"`
+	Internal   bool          `json:"internal"`
+	Arguments  amqp091.Table `json:"arguments"`
+}
+
+type queueDecl struct {
+	Name       string        `json:"name"`
+	Durable    bool          `json:"durable"`
+	AutoDelete bool          `json:"auto-delete"`
+	Exclusive  bool          `json:"exclusive"`
+	Arguments  amqp091.Table `json:"arguments"`
+}
+
+type bindingDecl struct {
+	Source          string        `json:"source"`
+	Destination     string        `json:"destination"`
+	DestinationType string        `json:"destination-type"`
+	RoutingKey      string        `json:"routing-key"`
+	Arguments       amqp091.Table `json:"arguments"`
+}
+
+// topology is declared by connect: its exchanges then queues, then the configured queue and exchange,
+// then its bindings, so that a redeclare under reconcile-topology can't drop one of them
+type topology struct {
+	Exchanges []exchangeDecl `json:"exchanges"`
+	Queues    []queueDecl    `json:"queues"`
+	Bindings  []bindingDecl  `json:"bindings"`
+}
+
+func loadTopology(path string) (*topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	desired := new(topology)
+	if err := decoder.Decode(desired); err != nil {
+		return nil, fmt.Errorf("topology-file %s: %w", path, err)
+	}
+
+	if err := desired.validate(); err != nil {
+		return nil, fmt.Errorf("topology-file %s: %w", path, err)
+	}
+
+	for i := range desired.Exchanges {
+		desired.Exchanges[i].Arguments = normalizeArgs(desired.Exchanges[i].Arguments)
+	}
+
+	for i := range desired.Queues {
+		desired.Queues[i].Arguments = normalizeArgs(desired.Queues[i].Arguments)
+	}
+
+	for i := range desired.Bindings {
+		desired.Bindings[i].Arguments = normalizeArgs(desired.Bindings[i].Arguments)
+	}
+
+	return desired, nil
+}
+
+func (desired *topology) validate() error {
+	for i, exchange := range desired.Exchanges {
+		if exchange.Name == "" {
+			return fmt.Errorf("exchanges[%d] has no name", i)
+		}
+
+		if exchange.Type == "" {
+			return fmt.Errorf("exchange %q has no type", exchange.Name)
+		}
+	}
+
+	for i, queue := range desired.Queues {
+		if queue.Name == "" {
+			return fmt.Errorf("queues[%d] has no name", i)
+		}
+	}
+
+	for i, binding := range desired.Bindings {
+		if binding.Source == "" {
+			return fmt.Errorf("bindings[%d] has no source, the default exchange can't be bound", i)
+		}
+
+		if binding.Destination == "" {
+			return fmt.Errorf("bindings[%d] has no destination", i)
+		}
+
+		switch binding.DestinationType {
+		case "", "queue", "exchange":
+		default:
+			return fmt.Errorf("bindings[%d] destination-type must be queue or exchange, got %q", i, binding.DestinationType)
+		}
+	}
+
+	return nil
+}
+
+// prefix namespaces every name in the topology, matching queueConfig.applyPrefix
+func (desired *topology) prefix(prefix string) {
+	for i := range desired.Exchanges {
+		desired.Exchanges[i].Name = prefix + desired.Exchanges[i].Name
+	}
+
+	for i := range desired.Queues {
+		desired.Queues[i].Name = prefix + desired.Queues[i].Name
+	}
+
+	for i := range desired.Bindings {
+		desired.Bindings[i].Source = prefix + desired.Bindings[i].Source
+		desired.Bindings[i].Destination = prefix + desired.Bindings[i].Destination
+	}
+}
+
+// declare declares the exchanges then the queues
+func (desired *topology) declare(d *declarer) error {
+	for _, exchange := range desired.Exchanges {
+		if err := d.declareExchange(exchange.Name, exchange.Type, exchange.Durable, exchange.AutoDelete, exchange.Internal, exchange.Arguments); err != nil {
+			return fmt.Errorf("declare exchange %q: %w", exchange.Name, err)
+		}
+	}
+
+	for _, queue := range desired.Queues {
+		if _, err := d.declareQueue(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.Arguments); err != nil {
+			return fmt.Errorf("declare queue %q: %w", queue.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// bind declares the bindings, once every exchange and queue they could refer to is declared
+func (desired *topology) bind(d *declarer) error {
+	for _, binding := range desired.Bindings {
+		var err error
+		if binding.DestinationType == "exchange" {
+			err = d.channel.ExchangeBind(binding.Destination, binding.RoutingKey, binding.Source, false, binding.Arguments)
+		} else {
+			err = d.channel.QueueBind(binding.Destination, binding.RoutingKey, binding.Source, false, binding.Arguments)
+		}
+
+		if err != nil {
+			return fmt.Errorf("bind %q to %q: %w", binding.Destination, binding.Source, err)
+		}
+	}
+
+	return nil
+}
+
+// normalizeArgs converts JSON numbers into the integer (or failing that float) values
+// the broker expects for arguments such as x-max-length or x-message-ttl
+func normalizeArgs(args amqp091.Table) amqp091.Table {
+	for key, value := range args {
+		args[key] = normalizeArg(value)
+	}
+
+	return args
+}
+
+func normalizeArg(value any) any {
+	switch value := value.(type) {
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+
+		if float, err := value.Float64(); err == nil {
+			return float
+		}
+
+		return value.String()
+	case map[string]any:
+		return normalizeArgs(value)
+	case []any:
+		for i := range value {
+			value[i] = normalizeArg(value[i])
+		}
+
+		return value
+	}
+
+	return value
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/psyduck-std/amqp/api"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// traceAttributes maps each trace-attributes name to the OpenTelemetry messaging attribute it sets
+var traceAttributes = map[string]string{
+	"queue":          "messaging.destination.name",
+	"exchange":       "messaging.rabbitmq.exchange",
+	"routing-key":    "messaging.rabbitmq.destination.routing_key",
+	"message-id":     "messaging.message.id",
+	"correlation-id": "messaging.message.conversation_id",
+	"redelivered":    "messaging.rabbitmq.redelivered",
+	"content-type":   "messaging.rabbitmq.content_type",
+	"priority":       "messaging.rabbitmq.priority",
+	"body-size":      "messaging.message.body.size",
+}
+
+func (config *queueConfig) validateTraceAttributes() error {
+	for _, name := range config.TraceAttributes {
+		if _, ok := traceAttributes[name]; !ok {
+			return fmt.Errorf("trace-attributes has no attribute %q", name)
+		}
+	}
+
+	return nil
+}
+
+// spanProperties are the properties of a message a span can be given as attributes
+type spanProperties struct {
+	queue, exchange, routingKey, messageID, correlationID, contentType string
+	redelivered                                                        bool
+	priority                                                           uint8
+	bodySize                                                           int
+}
+
+// consumed are the span properties of msg consumed from the queue
+func (config *queueConfig) consumed(msg *amqp091.Delivery) spanProperties {
+	return spanProperties{
+		queue:         config.Queue,
+		exchange:      msg.Exchange,
+		routingKey:    msg.RoutingKey,
+		messageID:     msg.MessageId,
+		correlationID: msg.CorrelationId,
+		contentType:   msg.ContentType,
+		redelivered:   msg.Redelivered,
+		priority:      msg.Priority,
+		bodySize:      len(msg.Body),
+	}
+}
+
+// span starts a span of name with the trace-attributes of props, or does nothing without a tracer
+func (config *queueConfig) span(tracer api.Tracer, name string, props spanProperties) func(err error) {
+	if tracer == nil {
+		return func(error) {}
+	}
+
+	values := map[string]string{
+		"queue":          props.queue,
+		"exchange":       props.exchange,
+		"routing-key":    props.routingKey,
+		"message-id":     props.messageID,
+		"correlation-id": props.correlationID,
+		"redelivered":    strconv.FormatBool(props.redelivered),
+		"content-type":   props.contentType,
+		"priority":       strconv.Itoa(int(props.priority)),
+		"body-size":      strconv.Itoa(props.bodySize),
+	}
+
+	attributes := map[string]string{"messaging.system": "rabbitmq"}
+	for _, name := range config.TraceAttributes {
+		if value := values[name]; value != "" {
+			attributes[traceAttributes[name]] = value
+		}
+	}
+
+	return tracer.Start(name, attributes)
+}
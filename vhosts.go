@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// vhostSource is one more connection, typically to another vhost, and queue the producer consumes from
+type vhostSource struct {
+	Connection string `cty:"connection"`
+	Queue      string `cty:"queue"`
+}
+
+// subscription is the connection and channel consuming one queue
+type subscription struct {
+	conn    *amqp091.Connection
+	channel *amqp091.Channel
+	queue   amqp091.Queue
+}
+
+// sourceConfigs are the configs of each queue to consume: the configured one, or one per source
+// sharing everything else
+func (config *queueConfig) sourceConfigs() []*queueConfig {
+	if len(config.Sources) == 0 {
+		return []*queueConfig{config}
+	}
+
+	configs := make([]*queueConfig, len(config.Sources))
+	for i, source := range config.Sources {
+		sourced := *config
+		sourced.Connection, sourced.Connections, sourced.Queue = source.Connection, nil, source.Queue
+		configs[i] = &sourced
+	}
+
+	return configs
+}
+
+// subscribe connects to every source, each on its own connection, closing them all if one fails
+func subscribe(config *queueConfig) ([]*subscription, error) {
+	configs := config.sourceConfigs()
+	subs := make([]*subscription, 0, len(configs))
+	for _, sourced := range configs {
+		conn, channel, queue, err := connect(sourced)
+		if err == nil && config.PrefetchCount != 0 {
+			if err = channel.Qos(config.PrefetchCount, 0, false); err != nil {
+				conn.Close()
+			}
+		}
+
+		if err != nil {
+			for _, sub := range subs {
+				sub.conn.Close()
+			}
+			return nil, err
+		}
+
+		subs = append(subs, &subscription{conn, channel, queue})
+	}
+
+	return subs, nil
+}
+
+// fanIn merges chans into one, closed once they all are, buffering up to size values
+func fanIn[T any](size int, chans ...<-chan T) <-chan T {
+	if len(chans) == 1 {
+		return chans[0]
+	}
+
+	merged := make(chan T, size)
+	pending := new(sync.WaitGroup)
+	for _, next := range chans {
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			for value := range next {
+				merged <- value
+			}
+		}()
+	}
+
+	go func() {
+		pending.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
+
+// buffered relays in through a buffer of size values, closing once in is closed and drained
+func buffered[T any](size int, in <-chan T) <-chan T {
+	if size == 0 {
+		return in
+	}
+
+	out := make(chan T, size)
+	go func() {
+		defer close(out)
+		for value := range in {
+			out <- value
+		}
+	}()
+
+	return out
+}
+
+// ackLatest acks the latest of msgs on each channel, along with everything delivered before it there
+func ackLatest(msgs []*amqp091.Delivery) error {
+	latest := make(map[amqp091.Acknowledger]*amqp091.Delivery, 1)
+	for _, msg := range msgs {
+		latest[msg.Acknowledger] = msg
+	}
+
+	for _, msg := range latest {
+		if err := msg.Ack(true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}